@@ -1,9 +1,16 @@
 package appcontext
 
 import (
+	"maps"
+	"sync"
+
 	"github.com/davenicholson-xyz/go-cachetools/cachetools"
+	"github.com/davenicholson-xyz/wallmancer/blacklist"
 	"github.com/davenicholson-xyz/wallmancer/config"
 	"github.com/davenicholson-xyz/wallmancer/download"
+	"github.com/davenicholson-xyz/wallmancer/favourites"
+	"github.com/davenicholson-xyz/wallmancer/history"
+	"github.com/davenicholson-xyz/wallmancer/setter"
 )
 
 type AppContext struct {
@@ -11,10 +18,28 @@ type AppContext struct {
 	CacheTools  *cachetools.CacheTools
 	URLBuilder  *download.URLBuilder
 	LinkManager *download.LinkManager
+	History     *history.History
+	Favourites  *favourites.Store
+	Blacklist   *blacklist.Blacklist
+	Setter      setter.Setter
+	Client      *download.Client
+
+	// CacheStatus is how the last query was answered: hit, miss or stale
+	CacheStatus string
+
+	// Skipped holds wallpapers that failed to apply during this run so they
+	// are not picked again
+	Skipped map[string]bool
+
+	// background tracks work started with Go, eg. prefetching
+	background *sync.WaitGroup
 }
 
 func NewAppContext() *AppContext {
-	return &AppContext{}
+	return &AppContext{
+		Skipped:    make(map[string]bool),
+		background: &sync.WaitGroup{},
+	}
 }
 
 func (app *AppContext) AddConfig(cfg *config.Config) {
@@ -32,3 +57,65 @@ func (app *AppContext) AddURLBuilder(url *download.URLBuilder) {
 func (app *AppContext) AddLinkManager(lm *download.LinkManager) {
 	app.LinkManager = lm
 }
+
+func (app *AppContext) AddHistory(h *history.History) {
+	app.History = h
+}
+
+func (app *AppContext) AddFavourites(store *favourites.Store) {
+	app.Favourites = store
+}
+
+func (app *AppContext) AddBlacklist(bl *blacklist.Blacklist) {
+	app.Blacklist = bl
+}
+
+func (app *AppContext) AddSetter(s setter.Setter) {
+	app.Setter = s
+}
+
+func (app *AppContext) AddClient(client *download.Client) {
+	app.Client = client
+}
+
+func (app *AppContext) SetCacheStatus(status string) {
+	app.CacheStatus = status
+}
+
+func (app *AppContext) Skip(candidate string) {
+	app.Skipped[candidate] = true
+}
+
+func (app *AppContext) IsSkipped(candidate string) bool {
+	return app.Skipped[candidate]
+}
+
+// ClearSkipped forgets the wallpapers skipped by an earlier run, eg. the last
+// rotation in daemon mode
+func (app *AppContext) ClearSkipped() {
+	clear(app.Skipped)
+}
+
+// Snapshot returns a copy of the app for work in the background, eg.
+// prefetching in the daemon. It has its own config and skipped wallpapers so
+// the app can go on changing while it runs.
+func (app *AppContext) Snapshot() *AppContext {
+	snapshot := *app
+	snapshot.Config = app.Config.Clone()
+	snapshot.Skipped = maps.Clone(app.Skipped)
+	return &snapshot
+}
+
+// Go runs fn in the background. Wait blocks until everything started with Go
+// has finished.
+func (app *AppContext) Go(fn func()) {
+	app.background.Add(1)
+	go func() {
+		defer app.background.Done()
+		fn()
+	}()
+}
+
+func (app *AppContext) Wait() {
+	app.background.Wait()
+}
@@ -0,0 +1,233 @@
+package blacklist
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/davenicholson-xyz/wallmancer/files"
+)
+
+var (
+	ErrEmptyTag = errors.New("No tag given")
+)
+
+const (
+	KindID   = "id"
+	KindURL  = "url"
+	KindHash = "hash"
+	KindTag  = "tag"
+)
+
+var wallhavenID = regexp.MustCompile(`wallhaven-([a-z0-9]+)\.[a-z]+$`)
+
+type Entry struct {
+	Added time.Time `json:"added"`
+	Kind  string    `json:"kind"`
+	Value string    `json:"value"`
+}
+
+// Blacklist is a persistent list of wallpapers that should never be applied.
+// Wallpapers are matched by wallhaven ID, source URL or the hash of the file,
+// or by any of their tags. It is safe to use from several goroutines.
+type Blacklist struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]map[string]bool
+	loaded  bool
+}
+
+func New(dir string) *Blacklist {
+	return &Blacklist{path: filepath.Join(dir, "blacklist.jsonl")}
+}
+
+// IDFromSource extracts the wallhaven ID from an image URL or filename
+func IDFromSource(source string) string {
+	match := wallhavenID.FindStringSubmatch(filepath.Base(source))
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+func (b *Blacklist) Entries() ([]Entry, error) {
+	file, err := os.Open(b.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read blacklist: %w", err)
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read blacklist: %w", err)
+	}
+
+	return entries, nil
+}
+
+// Ban adds the source URL, wallhaven ID and file hash of a wallpaper
+func (b *Blacklist) Ban(source string, path string) error {
+	var entries []Entry
+	now := time.Now()
+
+	entries = append(entries, Entry{Added: now, Kind: KindURL, Value: source})
+
+	if id := IDFromSource(source); id != "" {
+		entries = append(entries, Entry{Added: now, Kind: KindID, Value: id})
+	}
+
+	if path != "" {
+		hash, err := files.HashFile(path)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, Entry{Added: now, Kind: KindHash, Value: hash})
+	}
+
+	return b.append(entries)
+}
+
+// BanTag adds a tag. Tags are matched without regard to case.
+func (b *Blacklist) BanTag(tag string) error {
+	tag = normaliseTag(tag)
+	if tag == "" {
+		return ErrEmptyTag
+	}
+	return b.append([]Entry{{Added: time.Now(), Kind: KindTag, Value: tag}})
+}
+
+func (b *Blacklist) append(entries []Entry) error {
+	if err := os.MkdirAll(filepath.Dir(b.path), 0755); err != nil {
+		return fmt.Errorf("failed to create blacklist directory: %w", err)
+	}
+
+	file, err := os.OpenFile(b.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open blacklist: %w", err)
+	}
+	defer file.Close()
+
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+		if _, err := file.Write(append(line, '\n')); err != nil {
+			return fmt.Errorf("failed to write blacklist: %w", err)
+		}
+	}
+
+	b.mu.Lock()
+	b.loaded = false
+	b.mu.Unlock()
+	return nil
+}
+
+// IsBanned checks a candidate against the blacklist. Local files are also
+// checked by hash.
+func (b *Blacklist) IsBanned(candidate string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.load(); err != nil {
+		return false
+	}
+
+	if b.entries[KindURL][candidate] {
+		return true
+	}
+
+	if id := IDFromSource(candidate); id != "" && b.entries[KindID][id] {
+		return true
+	}
+
+	if len(b.entries[KindHash]) > 0 && !files.IsRemote(candidate) {
+		if hash, err := files.HashFile(candidate); err == nil && b.entries[KindHash][hash] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Tags returns the banned tags, sorted
+func (b *Blacklist) Tags() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.load(); err != nil {
+		return nil
+	}
+	return slices.Sorted(maps.Keys(b.entries[KindTag]))
+}
+
+// HasBannedTag reports whether any of the tags are banned
+func (b *Blacklist) HasBannedTag(tags []string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.load(); err != nil {
+		return false
+	}
+
+	for _, tag := range tags {
+		if b.entries[KindTag][normaliseTag(tag)] {
+			return true
+		}
+	}
+	return false
+}
+
+func normaliseTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// load reads the blacklist unless it already has been. b.mu is held by the
+// caller.
+func (b *Blacklist) load() error {
+	if b.loaded {
+		return nil
+	}
+
+	entries, err := b.Entries()
+	if err != nil {
+		return err
+	}
+
+	b.entries = map[string]map[string]bool{
+		KindID:   {},
+		KindURL:  {},
+		KindHash: {},
+		KindTag:  {},
+	}
+	for _, entry := range entries {
+		if _, ok := b.entries[entry.Kind]; ok {
+			b.entries[entry.Kind][entry.Value] = true
+		}
+	}
+
+	b.loaded = true
+	return nil
+}
@@ -0,0 +1,517 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/davenicholson-xyz/wallmancer/appcontext"
+	"github.com/davenicholson-xyz/wallmancer/files"
+	"github.com/davenicholson-xyz/wallmancer/providers"
+	"github.com/davenicholson-xyz/wallmancer/setter"
+)
+
+var (
+	ErrNoCachedQuery = errors.New("No cached query matches")
+)
+
+type command struct {
+	name string
+	run  func(ctx context.Context, app *appcontext.AppContext) (string, error)
+}
+
+// commands are checked in order, the first one whose flag is set is run.
+// Without any of them a new wallpaper is applied.
+var commands = []command{
+	{"clear", clearCache},
+	{"history", listHistory},
+	{"previous", previousWallpaper},
+	{"next", nextWallpaper},
+	{"favourite", favouriteCurrent},
+	{"unfavourite", unfavouriteCurrent},
+	{"ban_tag", banTag},
+	{"banned_tags", listBannedTags},
+	{"ban", banCurrent},
+	{"favourites", listFavourites},
+	{"info", showInfo},
+	{"queries", listQueries},
+	{"stats", cacheStats},
+	{"prune", pruneCache},
+	{"show", showQuery},
+}
+
+// selectCommand returns the command chosen by the flags
+func selectCommand(app *appcontext.AppContext) command {
+	for _, cmd := range commands {
+		if app.Config.GetBool(cmd.name) {
+			return cmd
+		}
+	}
+	return command{"apply", applyProvider}
+}
+
+func clearCache(ctx context.Context, app *appcontext.AppContext) (string, error) {
+	slog.Info("Clearing the cache")
+	err := app.CacheTools.Clear()
+	if err != nil {
+		return "", fmt.Errorf("Error deleting cache: %w", err)
+	}
+	return "Cache deleted", nil
+}
+
+// applyProvider selects and applies a new wallpaper from the configured
+// provider. With multi_monitor each output gets its own wallpaper.
+func applyProvider(ctx context.Context, app *appcontext.AppContext) (string, error) {
+	if app.Config.GetBool("multi_monitor") {
+		outputs, err := setter.Outputs()
+		if err != nil {
+			slog.Warn("Could not detect outputs, setting a single wallpaper", "error", err)
+		} else if len(outputs) > 1 {
+			return providers.ApplyOutputs(ctx, app, outputs)
+		}
+	}
+
+	prov := app.Config.GetStringWithDefault("provider", "wallhaven")
+	provider, exists := providers.GetProvider(prov)
+	if !exists {
+		return "", fmt.Errorf("Provider error: %w: %s", providers.ErrUnknownProvider, prov)
+	}
+
+	result, err := providers.Apply(ctx, app, provider)
+	if err != nil {
+		return "", fmt.Errorf("%w", err)
+	}
+
+	return result, nil
+}
+
+func previousWallpaper(ctx context.Context, app *appcontext.AppContext) (string, error) {
+	entries, err := app.History.Previous()
+	if err != nil {
+		return "", fmt.Errorf("%w", err)
+	}
+	return providers.ApplyHistory(ctx, app, entries)
+}
+
+func nextWallpaper(ctx context.Context, app *appcontext.AppContext) (string, error) {
+	entries, err := app.History.Next()
+	if err != nil {
+		return "", fmt.Errorf("%w", err)
+	}
+	return providers.ApplyHistory(ctx, app, entries)
+}
+
+func favouriteCurrent(ctx context.Context, app *appcontext.AppContext) (string, error) {
+	current, err := providers.Current(app)
+	if err != nil {
+		return "", fmt.Errorf("%w", err)
+	}
+	fav, err := app.Favourites.Add(current.Provider, current.Source, current.Path)
+	if err != nil {
+		return "", fmt.Errorf("%w", err)
+	}
+	return fmt.Sprintf("Added to favourites: %s", fav.Path), nil
+}
+
+func unfavouriteCurrent(ctx context.Context, app *appcontext.AppContext) (string, error) {
+	current, err := providers.Current(app)
+	if err != nil {
+		return "", fmt.Errorf("%w", err)
+	}
+	fav, err := app.Favourites.Remove(current.Source)
+	if err != nil {
+		return "", fmt.Errorf("%w", err)
+	}
+	return fmt.Sprintf("Removed from favourites: %s", fav.Source), nil
+}
+
+func banCurrent(ctx context.Context, app *appcontext.AppContext) (string, error) {
+	current, err := providers.Current(app)
+	if err != nil {
+		return "", fmt.Errorf("%w", err)
+	}
+	if err := app.Blacklist.Ban(current.Source, current.Path); err != nil {
+		return "", fmt.Errorf("%w", err)
+	}
+	return fmt.Sprintf("Banned: %s", current.Source), nil
+}
+
+func banTag(ctx context.Context, app *appcontext.AppContext) (string, error) {
+	tag := app.Config.GetString("tag")
+	if err := app.Blacklist.BanTag(tag); err != nil {
+		return "", fmt.Errorf("%w", err)
+	}
+	return fmt.Sprintf("Banned tag: %s", tag), nil
+}
+
+func listBannedTags(ctx context.Context, app *appcontext.AppContext) (string, error) {
+	tags := app.Blacklist.Tags()
+	if len(tags) == 0 {
+		return "No banned tags", nil
+	}
+	return strings.Join(tags, "\n"), nil
+}
+
+// showInfo describes the current wallpaper, with the full wallhaven details
+// when the wallpaper came from wallhaven
+func showInfo(ctx context.Context, app *appcontext.AppContext) (string, error) {
+	current, err := providers.Current(app)
+	if err != nil {
+		return "", fmt.Errorf("%w", err)
+	}
+
+	if id := providers.WallhavenID(current); id != "" {
+		wp, err := providers.WallhavenInfo(ctx, app, id)
+		if err != nil {
+			return "", fmt.Errorf("%w", err)
+		}
+		current.Wallpaper = wp
+	}
+
+	lines := []string{
+		fmt.Sprintf("Provider:   %s", current.Provider),
+		fmt.Sprintf("Source:     %s", current.Source),
+		fmt.Sprintf("File:       %s", current.Path),
+	}
+
+	if wp := current.Wallpaper; wp != nil {
+		var tags []string
+		for _, tag := range wp.Tags {
+			tags = append(tags, tag.Name)
+		}
+
+		uploader := "-"
+		if wp.Uploader != nil {
+			uploader = wp.Uploader.Username
+		}
+
+		lines = append(lines,
+			fmt.Sprintf("ID:         %s", wp.ID),
+			fmt.Sprintf("Link:       %s", wp.URL),
+			fmt.Sprintf("Resolution: %s (%s)", wp.Resolution, wp.Ratio),
+			fmt.Sprintf("Category:   %s (%s)", wp.Category, wp.Purity),
+			fmt.Sprintf("Tags:       %s", valueOr(strings.Join(tags, ", "), "-")),
+			fmt.Sprintf("Uploader:   %s", uploader),
+			fmt.Sprintf("Colours:    %s", valueOr(strings.Join(wp.Colors, " "), "-")),
+			fmt.Sprintf("Original:   %s", valueOr(wp.Source, "-")),
+			fmt.Sprintf("Size:       %s (%s)", formatBytes(wp.FileSize), wp.FileType),
+			fmt.Sprintf("Views:      %d", wp.Views),
+			fmt.Sprintf("Favourites: %d", wp.Favorites),
+		)
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+func valueOr(value string, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+func listHistory(ctx context.Context, app *appcontext.AppContext) (string, error) {
+	entries, err := app.History.Entries()
+	if err != nil {
+		return "", fmt.Errorf("%w", err)
+	}
+
+	if len(entries) == 0 {
+		return "No wallpaper history", nil
+	}
+
+	position := app.History.Position(entries)
+
+	var lines []string
+	for i, entry := range entries {
+		marker := " "
+		if i == position {
+			marker = "*"
+		}
+		lines = append(lines, fmt.Sprintf("%s %d\t%s\t%s\t%s\t%s", marker, i, entry.Time.Format(time.DateTime), entry.Provider, entry.Query, entry.Source))
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+func listFavourites(ctx context.Context, app *appcontext.AppContext) (string, error) {
+	favs, err := app.Favourites.List()
+	if err != nil {
+		return "", fmt.Errorf("%w", err)
+	}
+
+	if len(favs) == 0 {
+		return "No favourites saved", nil
+	}
+
+	var lines []string
+	for _, fav := range favs {
+		lines = append(lines, fmt.Sprintf("%s\t%s\t%s\t%s", fav.Added.Format(time.DateTime), fav.Provider, fav.Source, fav.Path))
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+func listQueries(ctx context.Context, app *appcontext.AppContext) (string, error) {
+	entries, err := files.ListQueries(app.CacheTools.Join(""))
+	if err != nil {
+		return "", fmt.Errorf("Error reading cache: %w", err)
+	}
+
+	if len(entries) == 0 {
+		return "No cached queries", nil
+	}
+
+	expiry := app.Config.GetIntWithDefault("expiry", 600)
+
+	var lines []string
+	for _, entry := range entries {
+		state := "stale"
+		if entry.IsFresh(expiry) {
+			state = "fresh"
+		}
+		age := time.Since(entry.Modified).Round(time.Second)
+		lines = append(lines, fmt.Sprintf("%s\t%s\t%s\t%d\t%s\t%s", entry.Hash, entry.Provider, state, entry.Count, age, entry.Query))
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// providerStats is the cache usage of a single provider
+type providerStats struct {
+	Provider string              `json:"provider"`
+	Queries  int                 `json:"queries"`
+	Fresh    int                 `json:"fresh"`
+	Images   int                 `json:"images"`
+	Files    int                 `json:"files"`
+	Bytes    int64               `json:"bytes"`
+	Counters files.CacheCounters `json:"counters"`
+}
+
+// collectCacheStats totals up the cache usage of each provider with a dir in
+// the cache
+func collectCacheStats(app *appcontext.AppContext) ([]providerStats, error) {
+	cacheDir := app.CacheTools.Join("")
+
+	dirs, err := os.ReadDir(cacheDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Error reading cache: %w", err)
+	}
+
+	entries, err := files.ListQueries(cacheDir)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading cache: %w", err)
+	}
+
+	expiry := app.Config.GetIntWithDefault("expiry", 600)
+
+	var stats []providerStats
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+
+		usage, err := files.GetCacheUsage(filepath.Join(cacheDir, dir.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("Error reading cache: %w", err)
+		}
+
+		counters, err := files.ReadCacheCounters(filepath.Join(cacheDir, dir.Name(), files.CountersFile))
+		if err != nil {
+			slog.Warn("Could not read cache counters", "provider", dir.Name(), "error", err)
+		}
+
+		provider := providerStats{
+			Provider: dir.Name(),
+			Images:   usage.Images,
+			Files:    usage.Files,
+			Bytes:    usage.Bytes,
+			Counters: counters,
+		}
+		for _, entry := range entries {
+			if entry.Provider != dir.Name() {
+				continue
+			}
+			provider.Queries++
+			if entry.IsFresh(expiry) {
+				provider.Fresh++
+			}
+		}
+
+		stats = append(stats, provider)
+	}
+
+	return stats, nil
+}
+
+func cacheStats(ctx context.Context, app *appcontext.AppContext) (string, error) {
+	cacheDir := app.CacheTools.Join("")
+
+	stats, err := collectCacheStats(app)
+	if err != nil {
+		return "", err
+	}
+
+	usage, err := files.GetCacheUsage(cacheDir)
+	if err != nil {
+		return "", fmt.Errorf("Error reading cache: %w", err)
+	}
+
+	queries, fresh := 0, 0
+	for _, provider := range stats {
+		queries += provider.Queries
+		fresh += provider.Fresh
+	}
+
+	lines := []string{
+		fmt.Sprintf("Directory: %s", cacheDir),
+		fmt.Sprintf("Queries:   %d (%d fresh, %d stale)", queries, fresh, queries-fresh),
+		fmt.Sprintf("Images:    %d", usage.Images),
+		fmt.Sprintf("Files:     %d", usage.Files),
+		fmt.Sprintf("Size:      %s", formatBytes(usage.Bytes)),
+	}
+
+	for _, provider := range stats {
+		c := provider.Counters
+		lookups := c["hit"] + c["miss"] + c["stale"] + c["offline"]
+		lines = append(lines,
+			"",
+			provider.Provider+":",
+			fmt.Sprintf("  Queries: %d (%d fresh, %d stale)", provider.Queries, provider.Fresh, provider.Queries-provider.Fresh),
+			fmt.Sprintf("  Images:  %d", provider.Images),
+			fmt.Sprintf("  Size:    %s", formatBytes(provider.Bytes)),
+			fmt.Sprintf("  Lookups: %d (%d hit, %d miss, %d stale, %d offline)", lookups, c["hit"], c["miss"], c["stale"], c["offline"]),
+		)
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// cachedQuery is a cached query along with the wallpapers stored for it
+type cachedQuery struct {
+	Query   files.QueryEntry         `json:"query"`
+	Results []providers.CachedResult `json:"results"`
+}
+
+// matchQueries returns the cached queries matching the query given to cache
+// show. A query matches on its hash, or a prefix of it, on the search terms or
+// on the sorting when it has no search terms, eg. hot.
+func matchQueries(app *appcontext.AppContext, query string) ([]cachedQuery, error) {
+	entries, err := files.ListQueries(app.CacheTools.Join(""))
+	if err != nil {
+		return nil, fmt.Errorf("Error reading cache: %w", err)
+	}
+
+	var matches []cachedQuery
+	for _, entry := range entries {
+		if !queryMatches(entry, query) {
+			continue
+		}
+		results, err := providers.CachedResults(entry)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading cache: %w", err)
+		}
+		matches = append(matches, cachedQuery{Query: entry, Results: results})
+	}
+
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoCachedQuery, query)
+	}
+
+	return matches, nil
+}
+
+func queryMatches(entry files.QueryEntry, query string) bool {
+	if entry.Query == query || (len(query) >= 6 && strings.HasPrefix(entry.Hash, query)) {
+		return true
+	}
+
+	u, err := url.Parse(entry.Query)
+	if err != nil {
+		return false
+	}
+	values := u.Query()
+	if q := values.Get("q"); q != "" {
+		return strings.EqualFold(q, query)
+	}
+	return values.Get("sorting") == query
+}
+
+func showQuery(ctx context.Context, app *appcontext.AppContext) (string, error) {
+	matches, err := matchQueries(app, app.Config.GetString("show_query"))
+	if err != nil {
+		return "", err
+	}
+
+	expiry := app.Config.GetIntWithDefault("expiry", 600)
+
+	var blocks []string
+	for _, match := range matches {
+		entry := match.Query
+		state := "stale"
+		if entry.IsFresh(expiry) {
+			state = "fresh"
+		}
+
+		lines := []string{
+			fmt.Sprintf("Query:   %s", entry.Query),
+			fmt.Sprintf("Hash:    %s", entry.Hash),
+			fmt.Sprintf("Fetched: %s ago (%s)", time.Since(entry.Modified).Round(time.Second), state),
+			fmt.Sprintf("Results: %d", entry.Count),
+		}
+		for _, result := range match.Results {
+			var flags []string
+			for flag, set := range map[string]bool{"seen": result.Seen, "queued": result.Queued, "downloaded": result.Downloaded, "dead": result.Dead} {
+				if set {
+					flags = append(flags, flag)
+				}
+			}
+			slices.Sort(flags)
+			lines = append(lines, fmt.Sprintf("%s\t%s\t%s\t%s", valueOr(result.ID, "-"), valueOr(result.Resolution, "-"), valueOr(strings.Join(flags, ","), "-"), result.Path))
+		}
+		blocks = append(blocks, strings.Join(lines, "\n"))
+	}
+
+	return strings.Join(blocks, "\n\n"), nil
+}
+
+// pruneCache removes expired query results and evicts images beyond the
+// max_cache_size and max_cache_age limits
+func pruneCache(ctx context.Context, app *appcontext.AppContext) (string, error) {
+	pruned, err := files.PruneQueries(app.CacheTools.Join(""), app.Config.GetIntWithDefault("expiry", 600))
+	if err != nil {
+		return "", fmt.Errorf("Error pruning cache: %w", err)
+	}
+
+	evicted, err := providers.EvictCache(app)
+	if err != nil {
+		return "", fmt.Errorf("Error pruning cache: %w", err)
+	}
+
+	var freed int64
+	for _, image := range evicted {
+		freed += image.Size
+	}
+
+	return fmt.Sprintf("Pruned %d cached queries, removed %d images (%s)", len(pruned), len(evicted), formatBytes(freed)), nil
+}
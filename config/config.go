@@ -1,17 +1,39 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 
 	"gopkg.in/yaml.v2"
 )
 
+var (
+	ErrKeyNotSet = errors.New("Config key is not set")
+)
+
 type Config struct {
 	values map[string]any
+
+	// text is each scalar as it was written in the config file, before YAML
+	// turned it into a number or bool
+	text map[string]string
+}
+
+// scalarText unmarshals a YAML scalar as the text it was written as. Lists and
+// mappings are left empty.
+type scalarText string
+
+func (s *scalarText) UnmarshalYAML(unmarshal func(any) error) error {
+	var text string
+	if err := unmarshal(&text); err == nil {
+		*s = scalarText(text)
+	}
+	return nil
 }
 
 func New(path string) (*Config, error) {
@@ -31,16 +53,21 @@ func load(filepath string) (*Config, error) {
 
 	var raw map[string]any
 	if err := yaml.Unmarshal(data, &raw); err != nil {
-		cfg := &Config{values: make(map[string]any)}
+		cfg := &Config{values: make(map[string]any), text: make(map[string]string)}
 		return cfg, nil
 	}
 
-	cfg := &Config{values: make(map[string]any)}
+	var text map[string]scalarText
+	yaml.Unmarshal(data, &text)
+
+	cfg := &Config{values: make(map[string]any), text: make(map[string]string)}
 
 	for key, value := range raw {
+		cfg.text[key] = string(text[key])
 		envKey := "WMCR_" + strings.ToUpper(key)
 		if envVal, exists := os.LookupEnv(envKey); exists {
 			value = convertType(value, envVal)
+			cfg.text[key] = envVal
 		}
 		cfg.values[key] = value
 	}
@@ -66,16 +93,134 @@ func convertType(original any, override string) any {
 	return override
 }
 
+// Clone returns a copy of the config that can be overridden without changing
+// the original
+func (c *Config) Clone() *Config {
+	cfg := &Config{values: make(map[string]any, len(c.values)), text: make(map[string]string, len(c.text))}
+	for k, v := range c.values {
+		cfg.values[k] = v
+	}
+	for k, v := range c.text {
+		cfg.text[k] = v
+	}
+	return cfg
+}
+
+// GetSection returns a nested mapping from the config, e.g.
+//
+//	outputs:
+//	  DP-1:
+//	    random: mountains
+//
+// gives {"DP-1": {"random": "mountains"}}
+func (c *Config) GetSection(key string) map[string]map[string]any {
+	section := make(map[string]map[string]any)
+
+	raw, ok := c.values[key].(map[any]any)
+	if !ok {
+		return section
+	}
+
+	for name, value := range raw {
+		values, ok := value.(map[any]any)
+		if !ok {
+			continue
+		}
+		entry := make(map[string]any)
+		for k, v := range values {
+			entry[fmt.Sprintf("%v", k)] = v
+		}
+		section[fmt.Sprintf("%v", name)] = entry
+	}
+
+	return section
+}
+
+// Get returns the value for a key as it was loaded
+func (c *Config) Get(key string) (any, bool) {
+	val, ok := c.values[key]
+	return val, ok
+}
+
+// SetInFile sets a key in the config file at path, keeping the other keys in
+// their order. Values that are bools or numbers are written as such.
+func SetInFile(path string, key string, value string) error {
+	var doc yaml.MapSlice
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Could not read config: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("Could not parse config: %w", err)
+	}
+
+	typed := typedValue(value)
+
+	found := false
+	for i := range doc {
+		if fmt.Sprintf("%v", doc[i].Key) == key {
+			doc[i].Value = typed
+			found = true
+		}
+	}
+	if !found {
+		doc = append(doc, yaml.MapItem{Key: key, Value: typed})
+	}
+
+	out, err := yaml.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("Could not write config: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("Could not create config directory: %w", err)
+	}
+
+	if err := os.WriteFile(path, out, 0600); err != nil {
+		return fmt.Errorf("Could not write config: %w", err)
+	}
+
+	return nil
+}
+
+// typedValue is the value to write for a value given as text. Only true and
+// false are bools and only ints written the way YAML reads them back are ints,
+// so eg. max_pages 1 stays a number and categories 010 stays as written.
+func typedValue(value string) any {
+	switch value {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if i, err := strconv.Atoi(value); err == nil && strconv.Itoa(i) == value {
+		return i
+	}
+	return value
+}
+
 func (c *Config) Override(key string, value any) {
 	c.values[key] = value
+	delete(c.text, key)
 }
 
 func (c *Config) Overrides(overrides map[string]any) {
 	for key, value := range overrides {
-		c.values[key] = value
+		c.Override(key, value)
 	}
 }
 
+// GetText returns a value as it was written in the config file, eg. 010
+// rather than the octal number YAML reads it as. Values set some other way
+// are the same as GetString.
+func (c *Config) GetText(key string) string {
+	if text := c.text[key]; text != "" {
+		return text
+	}
+	return c.GetString(key)
+}
+
 func (c *Config) GetString(key string) string {
 	if val, ok := c.values[key]; ok {
 		switch v := val.(type) {
@@ -118,6 +263,32 @@ func (c *Config) GetBool(key string) bool {
 	return false
 }
 
+// GetStringSlice returns a list value from the config. Lists can be given as
+// a YAML sequence or as a comma separated string (eg. from a flag).
+func (c *Config) GetStringSlice(key string) []string {
+	val, ok := c.values[key]
+	if !ok {
+		return nil
+	}
+
+	var result []string
+	switch v := val.(type) {
+	case []any:
+		for _, item := range v {
+			result = append(result, fmt.Sprintf("%v", item))
+		}
+	case []string:
+		result = append(result, v...)
+	default:
+		for _, item := range strings.Split(fmt.Sprintf("%v", v), ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				result = append(result, item)
+			}
+		}
+	}
+	return result
+}
+
 func (c *Config) GetStringWithDefault(key, defaultValue string) string {
 	if val := c.GetString(key); val != "" {
 		return val
@@ -140,7 +311,5 @@ func (c *Config) GetBoolWithDefault(key string, defaultValue bool) bool {
 }
 
 func (c *Config) FlagOverride(overrides map[string]any) {
-	for k, v := range overrides {
-		c.values[k] = v
-	}
+	c.Overrides(overrides)
 }
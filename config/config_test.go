@@ -0,0 +1,70 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestTypedValue(t *testing.T) {
+	tests := []struct {
+		value string
+		want  any
+	}{
+		{"true", true},
+		{"false", false},
+		{"1", 1},
+		{"0", 0},
+		{"-5", -5},
+		{"30", 30},
+		{"010", "010"},
+		{"000000", "000000"},
+		{"+1", "+1"},
+		{"t", "t"},
+		{"TRUE", "TRUE"},
+		{"1.5", "1.5"},
+		{"mountains", "mountains"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := typedValue(tt.value); got != tt.want {
+				t.Errorf("typedValue(%q) = %#v, want %#v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetInFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+
+	values := map[string]string{
+		"max_pages":  "1",
+		"categories": "010",
+		"colors":     "000000",
+		"nsfw":       "true",
+		"random":     "1",
+	}
+	for key, value := range values {
+		if err := SetInFile(path, key, value); err != nil {
+			t.Fatalf("SetInFile(%q, %q): %v", key, value, err)
+		}
+	}
+
+	cfg, err := New(path)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	if got := cfg.GetIntWithDefault("max_pages", 5); got != 1 {
+		t.Errorf("max_pages = %d, want 1", got)
+	}
+	for _, key := range []string{"categories", "colors", "random"} {
+		if got := cfg.GetText(key); got != values[key] {
+			t.Errorf("GetText(%q) = %q, want %q", key, got, values[key])
+		}
+	}
+	if !cfg.GetBool("nsfw") {
+		t.Errorf("nsfw = false, want true")
+	}
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"flag"
+	"io"
 	"os"
 )
 
@@ -11,12 +12,43 @@ type FlagSet struct {
 }
 
 func NewFlagSet() *FlagSet {
+	return NewNamedFlagSet(os.Args[0])
+}
+
+// NewNamedFlagSet creates a flag set for a subcommand
+func NewNamedFlagSet(name string) *FlagSet {
 	return &FlagSet{
-		flags:  flag.NewFlagSet(os.Args[0], flag.ExitOnError),
+		flags:  flag.NewFlagSet(name, flag.ExitOnError),
 		values: make(map[string]any),
 	}
 }
 
+// SetUsage sets the function that prints the help text for -h
+func (f *FlagSet) SetUsage(usage func()) {
+	f.flags.Usage = usage
+}
+
+func (f *FlagSet) PrintDefaults() {
+	f.flags.PrintDefaults()
+}
+
+func (f *FlagSet) Output() io.Writer {
+	return f.flags.Output()
+}
+
+// Has reports whether a flag has been defined
+func (f *FlagSet) Has(name string) bool {
+	_, ok := f.values[name]
+	return ok
+}
+
+// TakesValue reports whether a flag is followed by a value, ie. it is not a
+// bool flag
+func (f *FlagSet) TakesValue(name string) bool {
+	_, isBool := f.values[name].(*bool)
+	return f.Has(name) && !isBool
+}
+
 func (f *FlagSet) DefineString(name, value, usage string) {
 	var val string
 	f.flags.StringVar(&val, name, value, usage)
@@ -36,8 +68,36 @@ func (f *FlagSet) DefineBool(name string, value bool, usage string) {
 }
 
 func (f *FlagSet) Collect() map[string]any {
-	f.flags.Parse(os.Args[1:])
+	values, _ := f.Parse(os.Args[1:])
+	return values
+}
+
+// Parse parses args and returns the flags that were set along with the
+// positional arguments. Flags may come before, between or after the
+// positional arguments. Everything after -- is positional.
+func (f *FlagSet) Parse(args []string) (map[string]any, []string) {
+	var positional []string
+
+	for {
+		f.flags.Parse(args)
+		rest := f.flags.Args()
+		if len(rest) == 0 {
+			break
+		}
+
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			positional = append(positional, rest...)
+			break
+		}
+
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+
+	return f.collected(), positional
+}
 
+func (f *FlagSet) collected() map[string]any {
 	result := make(map[string]any)
 
 	for name, ptr := range f.values {
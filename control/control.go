@@ -0,0 +1,182 @@
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	CmdNext      = "next"
+	CmdPrevious  = "previous"
+	CmdPause     = "pause"
+	CmdResume    = "resume"
+	CmdFavourite = "favourite"
+	CmdBan       = "ban"
+	CmdStatus    = "status"
+	CmdSetQuery  = "set-query"
+)
+
+var Commands = []string{CmdNext, CmdPrevious, CmdPause, CmdResume, CmdFavourite, CmdBan, CmdStatus, CmdSetQuery}
+
+var (
+	ErrUnknownCommand = errors.New("Unknown command")
+	ErrNotRunning     = errors.New("Daemon is not running")
+)
+
+// Request is a single command read from the socket. The reply must be sent
+// on Reply exactly once.
+type Request struct {
+	Command string
+	Arg     string
+	Reply   chan Response
+}
+
+// Response is written back to the client as a single line of JSON
+type Response struct {
+	OK     bool   `json:"ok"`
+	Result string `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// SocketPath returns the default location of the control socket
+func SocketPath() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		return filepath.Join(os.TempDir(), fmt.Sprintf("wallmancer-%d.sock", os.Getuid()))
+	}
+	return filepath.Join(dir, "wallmancer.sock")
+}
+
+// Server accepts connections on a unix socket. Each connection sends one
+// command per line, e.g. "next" or "set-query mountains", and gets one line of
+// JSON back for every command.
+type Server struct {
+	path     string
+	listener net.Listener
+	requests chan Request
+}
+
+func Listen(path string) (*Server, error) {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("Control socket %s is already in use", path)
+	}
+
+	// Left behind by a daemon that did not shut down cleanly
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Could not remove stale socket: %w", err)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open control socket: %w", err)
+	}
+
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("Could not set control socket permissions: %w", err)
+	}
+
+	s := &Server{
+		path:     path,
+		listener: listener,
+		requests: make(chan Request),
+	}
+
+	go s.accept()
+
+	return s, nil
+}
+
+// Requests returns the channel commands are delivered on
+func (s *Server) Requests() <-chan Request {
+	return s.requests
+}
+
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	os.Remove(s.path)
+	return err
+}
+
+func (s *Server) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				slog.Error("Control socket error", "error", err)
+			}
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	encoder := json.NewEncoder(conn)
+
+	for scanner.Scan() {
+		command, arg, _ := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		if command == "" {
+			continue
+		}
+
+		var resp Response
+		if !slices.Contains(Commands, command) {
+			resp = Response{Error: fmt.Sprintf("%s: %s", ErrUnknownCommand, command)}
+		} else {
+			req := Request{Command: command, Arg: strings.TrimSpace(arg), Reply: make(chan Response, 1)}
+			s.requests <- req
+			resp = <-req.Reply
+		}
+
+		if err := encoder.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+// Send connects to the socket, sends a single command and returns the result
+func Send(path string, command string, arg string) (string, error) {
+	conn, err := net.DialTimeout("unix", path, 2*time.Second)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrNotRunning, err)
+	}
+	defer conn.Close()
+
+	line := strings.TrimSpace(command + " " + arg)
+	if _, err := fmt.Fprintln(conn, line); err != nil {
+		return "", fmt.Errorf("Could not send command: %w", err)
+	}
+
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return "", fmt.Errorf("Could not read response: %w", err)
+	}
+
+	if !resp.OK {
+		return "", errors.New(resp.Error)
+	}
+
+	return resp.Result, nil
+}
+
+// Reply builds the response for a command result
+func Reply(result string, err error) Response {
+	if err != nil {
+		return Response{Error: err.Error()}
+	}
+	return Response{OK: true, Result: result}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/davenicholson-xyz/wallmancer/appcontext"
+	"github.com/davenicholson-xyz/wallmancer/control"
+	"github.com/davenicholson-xyz/wallmancer/providers"
+)
+
+const defaultInterval = 30 * time.Minute
+
+var (
+	ErrInvalidInterval = errors.New("Invalid interval")
+)
+
+type daemon struct {
+	ctx       context.Context
+	app       *appcontext.AppContext
+	flgValues map[string]any
+	interval  time.Duration
+	ticker    *time.Ticker
+	paused    bool
+	query     string
+	lastTick  time.Time
+}
+
+// runDaemon applies a wallpaper straight away and then again every interval
+// until it receives SIGINT or SIGTERM. SIGHUP reloads the config file.
+// Commands from the control socket are handled between rotations.
+func runDaemon(ctx context.Context, app *appcontext.AppContext, flgValues map[string]any) (string, error) {
+	interval, err := parseInterval(app.Config.GetString("interval"))
+	if err != nil {
+		return "", err
+	}
+
+	server, err := control.Listen(app.Config.GetStringWithDefault("socket", control.SocketPath()))
+	if err != nil {
+		return "", err
+	}
+	defer server.Close()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	d := &daemon{ctx: ctx, app: app, flgValues: flgValues, interval: interval}
+
+	slog.Info("Starting daemon", "interval", interval)
+	d.rotate()
+
+	d.ticker = time.NewTicker(interval)
+	d.lastTick = time.Now()
+	defer d.ticker.Stop()
+
+	for {
+		select {
+		case <-d.ticker.C:
+			d.lastTick = time.Now()
+			if !d.paused {
+				d.rotate()
+			}
+
+		case req := <-server.Requests():
+			req.Reply <- control.Reply(d.handle(req))
+
+		case sig := <-signals:
+			if sig != syscall.SIGHUP {
+				slog.Info("Stopping daemon", "signal", sig)
+				return "Daemon stopped", nil
+			}
+			d.reload()
+		}
+	}
+}
+
+// handle runs a command received on the control socket
+func (d *daemon) handle(req control.Request) (string, error) {
+	slog.Info("Control command", "command", req.Command, "arg", req.Arg)
+
+	switch req.Command {
+	case control.CmdNext:
+		return d.next()
+
+	case control.CmdPrevious:
+		result, err := previousWallpaper(d.ctx, d.app)
+		if err == nil {
+			d.restartTimer()
+		}
+		return result, err
+
+	case control.CmdPause:
+		d.paused = true
+		return "Paused", nil
+
+	case control.CmdResume:
+		d.paused = false
+		d.restartTimer()
+		return "Resumed", nil
+
+	case control.CmdFavourite:
+		return favouriteCurrent(d.ctx, d.app)
+
+	case control.CmdBan:
+		banned, err := banCurrent(d.ctx, d.app)
+		if err != nil {
+			return "", err
+		}
+		result, err := d.next()
+		if err != nil {
+			return banned, err
+		}
+		return fmt.Sprintf("%s\n%s", banned, result), nil
+
+	case control.CmdStatus:
+		return d.status(), nil
+
+	case control.CmdSetQuery:
+		d.query = req.Arg
+		if d.query == "" {
+			d.reload()
+		} else {
+			d.applyQuery()
+		}
+		return d.next()
+	}
+
+	return "", fmt.Errorf("%w: %s", control.ErrUnknownCommand, req.Command)
+}
+
+func (d *daemon) next() (string, error) {
+	result, err := applyProvider(d.ctx, d.app)
+	if err != nil {
+		return "", err
+	}
+	d.restartTimer()
+	return result, nil
+}
+
+// rotate applies a new wallpaper. Errors are logged so that a failed fetch
+// does not stop the daemon.
+func (d *daemon) rotate() {
+	result, err := applyProvider(d.ctx, d.app)
+	if err != nil {
+		slog.Error("Could not change wallpaper", "error", err)
+		return
+	}
+	slog.Info("Wallpaper changed", "wallpaper", result)
+}
+
+func (d *daemon) restartTimer() {
+	if d.ticker != nil {
+		d.ticker.Reset(d.interval)
+		d.lastTick = time.Now()
+	}
+}
+
+func (d *daemon) reload() {
+	slog.Info("Reloading config")
+	cfg, err := loadConfig(d.flgValues)
+	if err != nil {
+		slog.Error("Could not reload config", "error", err)
+		return
+	}
+
+	interval, err := parseInterval(cfg.GetString("interval"))
+	if err != nil {
+		slog.Error("Could not reload config", "error", err)
+		return
+	}
+
+	if err := applyConfig(d.app, cfg); err != nil {
+		slog.Error("Could not reload config", "error", err)
+		return
+	}
+	d.applyQuery()
+
+	if interval != d.interval {
+		d.interval = interval
+		d.restartTimer()
+		slog.Info("Interval changed", "interval", interval)
+	}
+}
+
+// applyQuery overrides the search with the query from set-query
+func (d *daemon) applyQuery() {
+	if d.query == "" {
+		return
+	}
+	d.app.Config.Override("random", d.query)
+	d.app.Config.Override("hot", false)
+	d.app.Config.Override("top", false)
+}
+
+func (d *daemon) status() string {
+	state := "running"
+	if d.paused {
+		state = "paused"
+	}
+
+	current := "none"
+	if wp, err := providers.Current(d.app); err == nil {
+		current = wp.Source
+	}
+
+	status := fmt.Sprintf("state: %s\ninterval: %s\ncurrent: %s", state, d.interval, current)
+	if !d.paused {
+		remaining := time.Until(d.lastTick.Add(d.interval)).Round(time.Second)
+		status += fmt.Sprintf("\nnext change: %s", max(remaining, 0))
+	}
+	if d.query != "" {
+		status += fmt.Sprintf("\nquery: %s", d.query)
+	}
+
+	return status
+}
+
+// parseInterval accepts a duration (eg. 30m, 1h) or a number of seconds
+func parseInterval(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultInterval, nil
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		value = fmt.Sprintf("%ds", seconds)
+	}
+
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%w %q: %w", ErrInvalidInterval, value, err)
+	}
+
+	if interval <= 0 {
+		return 0, fmt.Errorf("%w %q: must be greater than zero", ErrInvalidInterval, value)
+	}
+
+	return interval, nil
+}
@@ -0,0 +1,112 @@
+package download
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var (
+	ErrInvalidProxy = errors.New("Invalid proxy")
+)
+
+const (
+	DefaultUserAgent = "wallmancer (+https://github.com/davenicholson-xyz/wallmancer)"
+
+	// wallhaven allows 45 API requests a minute
+	DefaultRateLimit = 45
+
+	DefaultTimeout         = 30 * time.Second
+	DefaultDownloadTimeout = 5 * time.Minute
+)
+
+// ClientOptions configures a Client. Zero values use the defaults.
+type ClientOptions struct {
+	// Timeout limits each attempt at an API request, DownloadTimeout each
+	// attempt at downloading an image. Waiting between retries is not limited.
+	Timeout         time.Duration
+	DownloadTimeout time.Duration
+
+	// Proxy is the URL of a proxy to use. Without it the HTTP_PROXY,
+	// HTTPS_PROXY and NO_PROXY environment variables are used.
+	Proxy string
+
+	UserAgent string
+	RateLimit int // API requests a minute
+
+	// Retries is how many times a failed request is retried, nil uses the
+	// default and 0 turns retrying off
+	Retries *int
+
+	// Transport replaces the default transport, eg. to send requests to an
+	// httptest server. Proxy is ignored when it is set.
+	Transport http.RoundTripper
+}
+
+// Client makes the HTTP requests for wallmancer
+type Client struct {
+	http            *http.Client
+	userAgent       string
+	timeout         time.Duration
+	downloadTimeout time.Duration
+	policy          RetryPolicy
+	limiter         *RateLimiter
+}
+
+func NewClient(opts ClientOptions) (*Client, error) {
+	transport := opts.Transport
+	if transport == nil {
+		proxy := http.ProxyFromEnvironment
+		if opts.Proxy != "" {
+			proxyURL, err := url.Parse(opts.Proxy)
+			if err != nil || proxyURL.Host == "" {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidProxy, opts.Proxy)
+			}
+			proxy = http.ProxyURL(proxyURL)
+		}
+
+		transport = &http.Transport{
+			Proxy:                 proxy,
+			DialContext:           (&net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: orDefault(opts.Timeout, DefaultTimeout),
+			IdleConnTimeout:       90 * time.Second,
+			MaxIdleConnsPerHost:   4,
+			ForceAttemptHTTP2:     true,
+		}
+	}
+
+	userAgent := opts.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+
+	policy := DefaultRetryPolicy
+	if opts.Retries != nil {
+		policy.Retries = max(*opts.Retries, 0)
+	}
+
+	rateLimit := opts.RateLimit
+	if rateLimit <= 0 {
+		rateLimit = DefaultRateLimit
+	}
+
+	return &Client{
+		http:            &http.Client{Transport: transport},
+		userAgent:       userAgent,
+		timeout:         orDefault(opts.Timeout, DefaultTimeout),
+		downloadTimeout: orDefault(opts.DownloadTimeout, DefaultDownloadTimeout),
+		policy:          policy,
+		limiter:         NewRateLimiter(rateLimit, max(rateLimit/9, 1)),
+	}, nil
+}
+
+func orDefault(d time.Duration, fallback time.Duration) time.Duration {
+	if d <= 0 {
+		return fallback
+	}
+	return d
+}
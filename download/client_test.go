@@ -0,0 +1,280 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client with short retry delays and a rate limit
+// that does not slow the tests down
+func newTestClient(t *testing.T, opts ClientOptions) *Client {
+	t.Helper()
+
+	if opts.RateLimit == 0 {
+		opts.RateLimit = 6000
+	}
+	client, err := NewClient(opts)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	client.policy.BaseDelay = 10 * time.Millisecond
+	client.policy.MaxDelay = 50 * time.Millisecond
+	return client
+}
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func TestUserAgent(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		want      string
+	}{
+		{"default", "", DefaultUserAgent},
+		{"configured", "wallmancer-test/1.0", "wallmancer-test/1.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got atomic.Value
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got.Store(r.UserAgent())
+				w.Write([]byte("{}"))
+			}))
+			defer server.Close()
+
+			client := newTestClient(t, ClientOptions{UserAgent: tt.userAgent})
+			if _, err := client.FetchJson(context.Background(), server.URL); err != nil {
+				t.Fatalf("FetchJson: %v", err)
+			}
+
+			if got.Load() != tt.want {
+				t.Errorf("User-Agent = %q, want %q", got.Load(), tt.want)
+			}
+		})
+	}
+}
+
+func TestProxy(t *testing.T) {
+	for _, proxy := range []string{"127.0.0.1:3128", "://proxy", "http://"} {
+		t.Run(proxy, func(t *testing.T) {
+			_, err := NewClient(ClientOptions{Proxy: proxy})
+			if !errors.Is(err, ErrInvalidProxy) {
+				t.Errorf("NewClient(Proxy: %q) error = %v, want ErrInvalidProxy", proxy, err)
+			}
+		})
+	}
+
+	t.Run("requests go through the proxy", func(t *testing.T) {
+		var got atomic.Value
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got.Store(r.URL.String())
+			w.Write([]byte("{}"))
+		}))
+		defer proxy.Close()
+
+		client := newTestClient(t, ClientOptions{Proxy: proxy.URL})
+		url := "http://wallhaven.invalid/api/v1/search"
+		if _, err := client.FetchJson(context.Background(), url); err != nil {
+			t.Fatalf("FetchJson: %v", err)
+		}
+
+		if got.Load() != url {
+			t.Errorf("proxy got request for %v, want %s", got.Load(), url)
+		}
+	})
+}
+
+func TestRetryServerError(t *testing.T) {
+	tests := []struct {
+		name     string
+		retries  *int
+		failures int32
+		wantErr  bool
+		want     int32
+	}{
+		{"recovers", nil, 2, false, 3},
+		{"gives up", intPtr(2), 5, true, 3},
+		{"retries off", intPtr(0), 1, true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if requests.Add(1) <= tt.failures {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.Write([]byte("{}"))
+			}))
+			defer server.Close()
+
+			client := newTestClient(t, ClientOptions{Retries: tt.retries})
+			_, err := client.FetchJson(context.Background(), server.URL)
+
+			if tt.wantErr != (err != nil) {
+				t.Errorf("FetchJson error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, ErrNetwork) {
+				t.Errorf("FetchJson error = %v, want ErrNetwork", err)
+			}
+			if requests.Load() != tt.want {
+				t.Errorf("made %d requests, want %d", requests.Load(), tt.want)
+			}
+		})
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		want    []error
+		notWant []error
+		retried bool
+	}{
+		{"not found", http.StatusNotFound, []error{ErrNotFound, ErrHTTPStatus}, []error{ErrNetwork}, false},
+		{"gone", http.StatusGone, []error{ErrNotFound, ErrHTTPStatus}, []error{ErrNetwork}, false},
+		{"unauthorized", http.StatusUnauthorized, []error{ErrHTTPStatus}, []error{ErrNetwork, ErrNotFound}, false},
+		{"bad request", http.StatusBadRequest, []error{ErrHTTPStatus}, []error{ErrNetwork, ErrNotFound}, false},
+		{"server error", http.StatusBadGateway, []error{ErrNetwork, ErrHTTPStatus}, []error{ErrNotFound}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			client := newTestClient(t, ClientOptions{Retries: intPtr(1)})
+			_, err := client.FetchJson(context.Background(), server.URL)
+
+			for _, want := range tt.want {
+				if !errors.Is(err, want) {
+					t.Errorf("FetchJson error = %v, want %v", err, want)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if errors.Is(err, notWant) {
+					t.Errorf("FetchJson error = %v, want not %v", err, notWant)
+				}
+			}
+
+			var status *StatusError
+			if !errors.As(err, &status) || status.Code != tt.status {
+				t.Errorf("FetchJson error = %v, want status %d", err, tt.status)
+			}
+
+			want := int32(1)
+			if tt.retried {
+				want = 2
+			}
+			if requests.Load() != want {
+				t.Errorf("made %d requests, want %d", requests.Load(), want)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	var requests atomic.Int32
+	var retried atomic.Int64
+	start := time.Now()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		retried.Store(int64(time.Since(start)))
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	// The wait for Retry-After is longer than the timeout of an attempt
+	client := newTestClient(t, ClientOptions{Timeout: 500 * time.Millisecond})
+	if _, err := client.FetchJson(context.Background(), server.URL); err != nil {
+		t.Fatalf("FetchJson: %v", err)
+	}
+
+	if requests.Load() != 2 {
+		t.Errorf("made %d requests, want 2", requests.Load())
+	}
+	if wait := time.Duration(retried.Load()); wait < time.Second {
+		t.Errorf("retried after %s, want at least 1s", wait)
+	}
+}
+
+func TestRetryAfterLastAttempt(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, ClientOptions{Retries: intPtr(0)})
+	start := time.Now()
+	_, err := client.FetchJson(context.Background(), server.URL)
+
+	if !errors.Is(err, ErrNetwork) {
+		t.Errorf("FetchJson error = %v, want ErrNetwork", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("FetchJson took %s, want it to fail without waiting", elapsed)
+	}
+}
+
+func TestRetryAfterTooLong(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, ClientOptions{})
+	_, err := client.FetchJson(context.Background(), server.URL)
+
+	if !errors.Is(err, ErrNetwork) {
+		t.Errorf("FetchJson error = %v, want ErrNetwork", err)
+	}
+	if requests.Load() != 1 {
+		t.Errorf("made %d requests, want 1", requests.Load())
+	}
+}
+
+func TestDownloadTimeoutPerAttempt(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			time.Sleep(300 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	// Every attempt fits in the timeout but all of them together do not
+	client := newTestClient(t, ClientOptions{DownloadTimeout: 400 * time.Millisecond, Retries: intPtr(3)})
+	client.policy.BaseDelay = 100 * time.Millisecond
+	client.policy.MaxDelay = 100 * time.Millisecond
+	err := client.DownloadImage(context.Background(), server.URL, t.TempDir()+"/image.png", 0)
+
+	if !errors.Is(err, ErrNetwork) {
+		t.Errorf("DownloadImage error = %v, want ErrNetwork", err)
+	}
+	if requests.Load() != 4 {
+		t.Errorf("made %d requests, want 4", requests.Load())
+	}
+}
@@ -1,35 +1,55 @@
 package download
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
-	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
+var (
+	ErrNetwork    = errors.New("Network error")
+	ErrNotFound   = errors.New("Not found")
+	ErrHTTPStatus = errors.New("Unexpected status code")
+)
+
+// StatusError holds the status code when the server answers a request with
+// anything but 200
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: %d", ErrHTTPStatus, e.Code)
+}
+
+func (e *StatusError) Is(target error) bool {
+	return target == ErrHTTPStatus
+}
+
 const (
 	letterBytes   = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 	letterIdxBits = 6
 	letterIdxMask = 1<<letterIdxBits - 1
 )
 
-func FetchJson(url string) ([]byte, error) {
-	resp, err := http.Get(url)
+// FetchJson fetches from the wallhaven API. Requests are rate limited and
+// failed requests are retried.
+func (c *Client) FetchJson(ctx context.Context, url string) ([]byte, error) {
+	resp, err := c.get(ctx, url, c.limiter, c.timeout)
 	if err != nil {
-		return nil, fmt.Errorf("HTTP request failed: %w", err)
+		return nil, err
 	}
-
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Unexpected status code: %d", resp.StatusCode)
-	}
-
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to read response body: %w", err)
+		return nil, fmt.Errorf("%w: failed to read response body: %w", ErrNetwork, err)
 	}
 
 	return body, nil
@@ -46,21 +66,52 @@ func GenerateSeed(length int) string {
 	return string(b)
 }
 
-func DownloadImage(url string, output string) error {
-	resp, err := http.Get(url)
+// DownloadImage downloads an image to output. It is written to a temporary
+// file first and only moved into place once it has been checked, so output
+// is never left holding an error page or a partial image. When size is not 0
+// the download must be exactly that many bytes.
+func (c *Client) DownloadImage(ctx context.Context, url string, output string, size int64) error {
+	resp, err := c.get(ctx, url, nil, c.downloadTimeout)
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return err
 	}
 	defer resp.Body.Close()
 
-	file, err := os.Create(output)
+	contentType := resp.Header.Get("Content-Type")
+	if contentType != "" && !strings.HasPrefix(contentType, "image/") && !strings.HasPrefix(contentType, "application/octet-stream") {
+		return fmt.Errorf("%w: %s served as %s", ErrInvalidImage, url, contentType)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(output), ".download-*")
 	if err != nil {
 		return fmt.Errorf("%w", err)
 	}
-	defer file.Close()
+	defer os.Remove(tmp.Name())
 
-	_, err = io.Copy(file, resp.Body)
+	written, err := io.Copy(tmp, resp.Body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
+		return fmt.Errorf("%w: %w: %w", ErrNetwork, ErrIncomplete, err)
+	}
+
+	if resp.ContentLength >= 0 && written != resp.ContentLength {
+		return fmt.Errorf("%w: got %d of %d bytes", ErrIncomplete, written, resp.ContentLength)
+	}
+	if size > 0 && written != size {
+		return fmt.Errorf("%w: got %d bytes, expected %d", ErrIncomplete, written, size)
+	}
+
+	if _, err := ValidateImage(tmp.Name()); err != nil {
+		return fmt.Errorf("%s: %w", url, err)
+	}
+
+	if err := os.Rename(tmp.Name(), output); err != nil {
 		return fmt.Errorf("%w", err)
 	}
 
@@ -0,0 +1,96 @@
+package download
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+)
+
+var (
+	ErrInvalidImage = errors.New("Not a valid image")
+	ErrIncomplete   = errors.New("Image download is incomplete")
+)
+
+// ImageInfo is what was read from an image's header
+type ImageInfo struct {
+	Format string
+	Width  int
+	Height int
+}
+
+// ValidateImage checks that the file starts with a png, jpeg, gif or webp
+// header that can be decoded
+func ValidateImage(path string) (ImageInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return ImageInfo{}, fmt.Errorf("%w", err)
+	}
+	defer file.Close()
+
+	header := make([]byte, 30)
+	n, _ := io.ReadFull(file, header)
+	header = header[:n]
+
+	if isWebp(header) {
+		width, height, err := webpSize(header)
+		if err != nil {
+			return ImageInfo{}, err
+		}
+		return ImageInfo{Format: "webp", Width: width, Height: height}, nil
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return ImageInfo{}, fmt.Errorf("%w", err)
+	}
+
+	cfg, format, err := image.DecodeConfig(file)
+	if err != nil {
+		return ImageInfo{}, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+
+	return ImageInfo{Format: format, Width: cfg.Width, Height: cfg.Height}, nil
+}
+
+func isWebp(header []byte) bool {
+	return len(header) >= 12 && bytes.Equal(header[0:4], []byte("RIFF")) && bytes.Equal(header[8:12], []byte("WEBP"))
+}
+
+// webpSize reads the canvas size from the first chunk of a webp file, which
+// is one of the lossy (VP8), lossless (VP8L) or extended (VP8X) formats
+func webpSize(header []byte) (int, int, error) {
+	if len(header) < 30 {
+		return 0, 0, fmt.Errorf("%w: webp header is too short", ErrInvalidImage)
+	}
+
+	chunk := header[12:16]
+	data := header[20:]
+
+	switch string(chunk) {
+	case "VP8 ":
+		if !bytes.Equal(data[3:6], []byte{0x9d, 0x01, 0x2a}) {
+			return 0, 0, fmt.Errorf("%w: bad VP8 start code", ErrInvalidImage)
+		}
+		width := int(binary.LittleEndian.Uint16(data[6:8]) & 0x3fff)
+		height := int(binary.LittleEndian.Uint16(data[8:10]) & 0x3fff)
+		return width, height, nil
+	case "VP8L":
+		if data[0] != 0x2f {
+			return 0, 0, fmt.Errorf("%w: bad VP8L signature", ErrInvalidImage)
+		}
+		bits := binary.LittleEndian.Uint32(data[1:5])
+		return int(bits&0x3fff) + 1, int((bits>>14)&0x3fff) + 1, nil
+	case "VP8X":
+		width := int(data[4]) | int(data[5])<<8 | int(data[6])<<16
+		height := int(data[7]) | int(data[8])<<8 | int(data[9])<<16
+		return width + 1, height + 1, nil
+	}
+
+	return 0, 0, fmt.Errorf("%w: unknown webp chunk %q", ErrInvalidImage, chunk)
+}
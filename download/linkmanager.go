@@ -1,15 +1,20 @@
 package download
 
-import "sync"
+import (
+	"sort"
+	"sync"
+)
 
 type LinkManager struct {
 	links []string
+	pages map[int][]string
 	mu    sync.RWMutex
 }
 
 func NewLinkManager() *LinkManager {
 	return &LinkManager{
 		links: make([]string, 0),
+		pages: make(map[int][]string),
 	}
 }
 
@@ -20,10 +25,42 @@ func (lm *LinkManager) AddLinks(newLinks []string) {
 	lm.links = append(lm.links, newLinks...)
 }
 
+// AddPage stores the links for a single page of results. Pages may be added
+// in any order, GetLinks returns them sorted by page number.
+func (lm *LinkManager) AddPage(page int, newLinks []string) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	lm.pages[page] = append(lm.pages[page], newLinks...)
+}
+
 func (lm *LinkManager) GetLinks() []string {
-	return lm.links
+	lm.mu.RLock()
+	defer lm.mu.RUnlock()
+
+	links := make([]string, 0, len(lm.links))
+	links = append(links, lm.links...)
+
+	pageNumbers := make([]int, 0, len(lm.pages))
+	for page := range lm.pages {
+		pageNumbers = append(pageNumbers, page)
+	}
+	sort.Ints(pageNumbers)
+
+	for _, page := range pageNumbers {
+		links = append(links, lm.pages[page]...)
+	}
+
+	return links
 }
 
 func (lm *LinkManager) Count() int {
-	return len(lm.links)
+	lm.mu.RLock()
+	defer lm.mu.RUnlock()
+
+	count := len(lm.links)
+	for _, links := range lm.pages {
+		count += len(links)
+	}
+	return count
 }
@@ -0,0 +1,64 @@
+package download
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket. It starts full with burst tokens and refills
+// at perMinute-burst tokens a minute, so no more than perMinute requests are
+// made in any minute.
+type RateLimiter struct {
+	mu       sync.Mutex
+	tokens   float64
+	burst    float64
+	interval time.Duration // time to refill a single token
+	last     time.Time
+}
+
+func NewRateLimiter(perMinute int, burst int) *RateLimiter {
+	perMinute = max(perMinute, 1)
+	burst = min(max(burst, 1), perMinute)
+	refill := max(perMinute-burst, 1)
+
+	return &RateLimiter{
+		tokens:   float64(burst),
+		burst:    float64(burst),
+		interval: time.Minute / time.Duration(refill),
+		last:     time.Now(),
+	}
+}
+
+// Wait blocks until a request can be made
+func (r *RateLimiter) Wait(ctx context.Context) error {
+	for {
+		r.mu.Lock()
+		now := time.Now()
+		r.tokens = min(r.burst, r.tokens+float64(now.Sub(r.last))/float64(r.interval))
+		r.last = now
+
+		if r.tokens >= 1 {
+			r.tokens--
+			r.mu.Unlock()
+			return nil
+		}
+
+		wait := time.Duration((1 - r.tokens) * float64(r.interval))
+		r.mu.Unlock()
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Drain empties the bucket, used when the server says we are going too fast
+func (r *RateLimiter) Drain() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tokens = 0
+	r.last = time.Now()
+}
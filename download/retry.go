@@ -0,0 +1,174 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how failed requests are retried. Network errors, 429
+// and 5xx responses are retried, anything else fails straight away.
+// Once the retries run out the error is ErrNetwork.
+type RetryPolicy struct {
+	Retries       int
+	BaseDelay     time.Duration
+	MaxDelay      time.Duration
+	MaxRetryAfter time.Duration // longest Retry-After that is waited for
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	Retries:       3,
+	BaseDelay:     500 * time.Millisecond,
+	MaxDelay:      30 * time.Second,
+	MaxRetryAfter: 2 * time.Minute,
+}
+
+// get makes a GET request, retrying with backoff when it fails. When limiter
+// is not nil every attempt waits for it first. timeout limits each attempt,
+// including reading the body, while waiting between attempts only stops when
+// ctx is done. The caller closes the body of the returned response, which
+// always has status 200. A response the server rejects fails with a
+// StatusError.
+func (c *Client) get(ctx context.Context, url string, limiter *RateLimiter, timeout time.Duration) (*http.Response, error) {
+	policy := c.policy
+
+	var lastErr error
+	for attempt := 0; attempt <= policy.Retries; attempt++ {
+		if attempt > 0 {
+			slog.Warn("Retrying request", "attempt", attempt, "error", lastErr)
+		}
+
+		if limiter != nil {
+			if err := limiter.Wait(ctx); err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrNetwork, err)
+			}
+		}
+
+		resp, err := c.do(ctx, url, timeout)
+		if err != nil {
+			lastErr = fmt.Errorf("%w: HTTP request failed: %w", ErrNetwork, err)
+			if ctx.Err() != nil || attempt == policy.Retries {
+				return nil, lastErr
+			}
+			if err := sleep(ctx, policy.backoff(attempt)); err != nil {
+				return nil, lastErr
+			}
+			continue
+		}
+
+		if resp.StatusCode == http.StatusOK {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		status := &StatusError{Code: resp.StatusCode}
+		lastErr = fmt.Errorf("%w: %w", ErrNetwork, status)
+
+		switch {
+		case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+			return nil, fmt.Errorf("%w: %w", ErrNotFound, status)
+		case resp.StatusCode == http.StatusTooManyRequests:
+			if limiter != nil {
+				limiter.Drain()
+			}
+			if attempt == policy.Retries {
+				return nil, lastErr
+			}
+			delay, ok := retryAfter(resp.Header.Get("Retry-After"))
+			if !ok {
+				delay = policy.backoff(attempt)
+			}
+			if delay > policy.MaxRetryAfter {
+				return nil, fmt.Errorf("%w (retry after %s)", lastErr, delay)
+			}
+			if err := sleep(ctx, delay); err != nil {
+				return nil, lastErr
+			}
+		case resp.StatusCode >= 500:
+			if attempt == policy.Retries {
+				return nil, lastErr
+			}
+			if err := sleep(ctx, policy.backoff(attempt)); err != nil {
+				return nil, lastErr
+			}
+		default:
+			return nil, status
+		}
+	}
+
+	return nil, lastErr
+}
+
+// do makes a single attempt at a request. The timeout is only cancelled once
+// the body of the response has been closed.
+func (c *Client) do(ctx context.Context, url string, timeout time.Duration) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelBody cancels the context of its request when it is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// backoff doubles the delay for each attempt up to MaxDelay. The delay is
+// jittered between half and all of it so parallel requests spread out.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay << min(attempt, 16)
+	if delay <= 0 || delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// retryAfter parses a Retry-After header, given as seconds or an HTTP date
+func retryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
+
+// sleep waits for d, returning early with an error if ctx is cancelled
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -127,6 +127,17 @@ func (u *URLBuilder) BuildWithout(key string) string {
 	return u.baseURL + "?" + newValues.Encode()
 }
 
+// Clone returns a copy of the builder that can be modified independently
+func (u *URLBuilder) Clone() *URLBuilder {
+	newBuilder := NewURL(u.baseURL)
+
+	for k, v := range u.values {
+		newBuilder.values[k] = append([]string(nil), v...)
+	}
+
+	return newBuilder
+}
+
 func (u *URLBuilder) Without(key string) *URLBuilder {
 	newBuilder := NewURL(u.baseURL)
 
@@ -0,0 +1,172 @@
+package favourites
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/davenicholson-xyz/wallmancer/files"
+)
+
+var (
+	ErrAlreadyFavourite = errors.New("Wallpaper is already a favourite")
+	ErrNotFavourite     = errors.New("Wallpaper is not a favourite")
+	ErrNoFavourites     = errors.New("No favourites saved")
+)
+
+type Favourite struct {
+	Added    time.Time `json:"added"`
+	Provider string    `json:"provider"`
+	Source   string    `json:"source"`
+	Path     string    `json:"path"`
+}
+
+// Store keeps copies of favourite wallpapers in a directory outside the cache
+// along with an index of where each one came from.
+type Store struct {
+	dir string
+}
+
+func New(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+func (s *Store) index() string {
+	return filepath.Join(s.dir, "favourites.jsonl")
+}
+
+func (s *Store) List() ([]Favourite, error) {
+	file, err := os.Open(s.index())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read favourites: %w", err)
+	}
+	defer file.Close()
+
+	var favs []Favourite
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var fav Favourite
+		if err := json.Unmarshal(scanner.Bytes(), &fav); err != nil {
+			continue
+		}
+		favs = append(favs, fav)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read favourites: %w", err)
+	}
+
+	return favs, nil
+}
+
+// Contains reports whether source has already been saved
+func (s *Store) Contains(source string) (bool, error) {
+	favs, err := s.List()
+	if err != nil {
+		return false, err
+	}
+	for _, fav := range favs {
+		if fav.Source == source {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Add copies the image at path into the store and records it as a favourite
+func (s *Store) Add(provider string, source string, path string) (Favourite, error) {
+	exists, err := s.Contains(source)
+	if err != nil {
+		return Favourite{}, err
+	}
+	if exists {
+		return Favourite{}, fmt.Errorf("%w: %s", ErrAlreadyFavourite, source)
+	}
+
+	// Images from different dirs can share a name, so the copy is named by
+	// its contents as well
+	hash, err := files.HashFile(path)
+	if err != nil {
+		return Favourite{}, err
+	}
+	output := filepath.Join(s.dir, "images", provider, hash[:16]+"-"+filepath.Base(path))
+	if err := files.CopyFile(path, output); err != nil {
+		return Favourite{}, err
+	}
+
+	fav := Favourite{Added: time.Now(), Provider: provider, Source: source, Path: output}
+
+	favs, err := s.List()
+	if err != nil {
+		return Favourite{}, err
+	}
+
+	if err := s.write(append(favs, fav)); err != nil {
+		return Favourite{}, err
+	}
+
+	return fav, nil
+}
+
+// Remove deletes the favourite with the given source along with its image
+func (s *Store) Remove(source string) (Favourite, error) {
+	favs, err := s.List()
+	if err != nil {
+		return Favourite{}, err
+	}
+
+	for i, fav := range favs {
+		if fav.Source != source && fav.Path != source {
+			continue
+		}
+
+		rest := append(favs[:i:i], favs[i+1:]...)
+		if err := s.write(rest); err != nil {
+			return Favourite{}, err
+		}
+
+		// The same image saved from another source shares the copy
+		if slices.ContainsFunc(rest, func(other Favourite) bool { return other.Path == fav.Path }) {
+			return fav, nil
+		}
+
+		if err := os.Remove(fav.Path); err != nil && !os.IsNotExist(err) {
+			return Favourite{}, fmt.Errorf("failed to remove favourite image: %w", err)
+		}
+
+		return fav, nil
+	}
+
+	return Favourite{}, fmt.Errorf("%w: %s", ErrNotFavourite, source)
+}
+
+func (s *Store) write(favs []Favourite) error {
+	var content strings.Builder
+	for _, fav := range favs {
+		line, err := json.Marshal(fav)
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+		content.Write(line)
+		content.WriteString("\n")
+	}
+
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create favourites directory: %w", err)
+	}
+
+	if err := os.WriteFile(s.index(), []byte(content.String()), 0600); err != nil {
+		return fmt.Errorf("failed to write favourites: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package files
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// CountersFile is the name of the file in each providers cache dir counting
+// how often cached results were used
+const CountersFile = "counters.json"
+
+// CacheCounters counts the cache status of each query, eg. hit or miss
+type CacheCounters map[string]int
+
+// ReadCacheCounters reads a counters file, a missing file has no counts
+func ReadCacheCounters(filename string) (CacheCounters, error) {
+	counters := make(CacheCounters)
+
+	content, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return counters, nil
+	}
+	if err != nil {
+		return counters, fmt.Errorf("failed to read counters: %w", err)
+	}
+
+	if err := json.Unmarshal(content, &counters); err != nil {
+		return make(CacheCounters), fmt.Errorf("failed to read counters: %w", err)
+	}
+
+	return counters, nil
+}
+
+// IncrementCacheCounter adds one to the count for status in a counters file
+func IncrementCacheCounter(filename string, status string) error {
+	// A damaged counters file is started again
+	counters, _ := ReadCacheCounters(filename)
+	counters[status]++
+
+	data, err := json.Marshal(counters)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return WriteFileAtomic(filename, data)
+}
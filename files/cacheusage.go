@@ -0,0 +1,48 @@
+package files
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+)
+
+// CacheUsage is the disk space used by the cache
+type CacheUsage struct {
+	Files  int
+	Images int
+	Bytes  int64
+}
+
+// GetCacheUsage walks the cache directory and totals up its files
+func GetCacheUsage(cacheDir string) (CacheUsage, error) {
+	var usage CacheUsage
+	if !PathExists(cacheDir) {
+		return usage, nil
+	}
+
+	err := filepath.WalkDir(cacheDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		usage.Files++
+		usage.Bytes += info.Size()
+		if IsImageFile(path) {
+			usage.Images++
+		}
+		return nil
+	})
+	if err != nil {
+		return usage, fmt.Errorf("failed to read cache: %w", err)
+	}
+
+	return usage, nil
+}
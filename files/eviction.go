@@ -0,0 +1,164 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidSize = errors.New("Invalid size")
+	ErrInvalidAge  = errors.New("Invalid age")
+)
+
+// CachedImage is a downloaded image. Used is its modification time, which is
+// updated with Touch whenever a cached copy is used.
+type CachedImage struct {
+	Path string
+	Size int64
+	Used time.Time
+}
+
+// EvictImages removes images from the cache, least recently used first, until
+// they take up no more than maxBytes, along with any not used within maxAge.
+// A limit of 0 is not applied. Paths in keep are never removed.
+func EvictImages(cacheDir string, maxBytes int64, maxAge time.Duration, keep map[string]bool) ([]CachedImage, error) {
+	if maxBytes <= 0 && maxAge <= 0 {
+		return nil, nil
+	}
+
+	images, err := cachedImages(cacheDir)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].Used.Before(images[j].Used)
+	})
+
+	var total int64
+	for _, image := range images {
+		total += image.Size
+	}
+
+	var removed []CachedImage
+	for _, image := range images {
+		if keep[image.Path] {
+			continue
+		}
+
+		tooOld := maxAge > 0 && time.Since(image.Used) > maxAge
+		tooBig := maxBytes > 0 && total > maxBytes
+		if !tooOld && !tooBig {
+			continue
+		}
+
+		if err := os.Remove(image.Path); err != nil && !os.IsNotExist(err) {
+			return removed, fmt.Errorf("failed to remove image: %w", err)
+		}
+		total -= image.Size
+		removed = append(removed, image)
+	}
+
+	return removed, nil
+}
+
+func cachedImages(cacheDir string) ([]CachedImage, error) {
+	var images []CachedImage
+
+	if !PathExists(cacheDir) {
+		return images, nil
+	}
+
+	err := filepath.WalkDir(cacheDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !IsImageFile(path) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		images = append(images, CachedImage{Path: path, Size: info.Size(), Used: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache: %w", err)
+	}
+
+	return images, nil
+}
+
+// Touch marks a file as just used
+func Touch(path string) error {
+	now := time.Now()
+	return os.Chtimes(path, now, now)
+}
+
+// ParseSize parses a size in bytes with an optional unit, eg. 500MB or 2G.
+// Units are powers of 1024.
+func ParseSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "" {
+		return 0, nil
+	}
+
+	units := []struct {
+		suffix string
+		size   int64
+	}{
+		{"TIB", 1 << 40}, {"GIB", 1 << 30}, {"MIB", 1 << 20}, {"KIB", 1 << 10},
+		{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+		{"B", 1},
+	}
+
+	multiplier := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.size
+			break
+		}
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidSize, value)
+	}
+
+	return int64(number * float64(multiplier)), nil
+}
+
+// ParseAge parses a duration that can also be given in days, eg. 30d or 12h
+func ParseAge(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("%w: %s", ErrInvalidAge, value)
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+
+	age, err := time.ParseDuration(value)
+	if err != nil || age < 0 {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidAge, value)
+	}
+
+	return age, nil
+}
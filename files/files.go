@@ -1,8 +1,11 @@
 package files
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"math/rand"
 	"os"
 	"path/filepath"
@@ -25,13 +28,9 @@ func IsFullPath(path string) bool {
 
 func PathExists(path string) bool {
 	if _, err := os.Stat(path); err != nil {
-		if os.IsNotExist(err) {
-			return false
-		} else {
-			return true
-		}
+		return !os.IsNotExist(err)
 	}
-	return false
+	return true
 }
 
 func GetUserConfigDir() (string, bool) {
@@ -92,6 +91,64 @@ func GetCacheDir() (string, error) {
 	return cacheDir, nil
 }
 
+// GetDataDir returns the directory for persistent data that should survive
+// clearing the cache (eg. favourites)
+func GetDataDir() (string, error) {
+	var dataDir string
+
+	switch runtime.GOOS {
+	case "windows":
+		dataDir = filepath.Join(os.Getenv("LOCALAPPDATA"), "wallmancer", "data")
+	case "darwin":
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrUserHomeNotFound, err)
+		}
+		dataDir = filepath.Join(home, "Library", "Application Support", "wallmancer")
+	default: // Linux/Unix
+		if xdg := os.Getenv("XDG_DATA_HOME"); xdg != "" {
+			dataDir = filepath.Join(xdg, "wallmancer")
+			break
+		}
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrUserHomeNotFound, err)
+		}
+		dataDir = filepath.Join(home, ".local", "share", "wallmancer")
+	}
+
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	return dataDir, nil
+}
+
+// CopyFile copies src to dst, creating any parent directories of dst
+func CopyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("failed to create directories: %w", err)
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to copy file: %w", err)
+	}
+
+	return out.Close()
+}
+
 func WriteStringToCache(filename string, str string) error {
 	// Get or create cache directory
 	cacheDir, err := GetCacheDir()
@@ -170,6 +227,53 @@ func ReadFromCache(path string) (string, error) {
 	return string(content), nil
 }
 
+// WriteFileAtomic writes a file by writing a temporary file next to it and
+// renaming it, so readers never see a partly written file
+func WriteFileAtomic(filename string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return fmt.Errorf("failed to create directories: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+
+	return nil
+}
+
+// AppendLine adds a line to the end of a file, creating it if needed
+func AppendLine(filename string, line string) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return fmt.Errorf("failed to create directories: %w", err)
+	}
+
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+
+	if _, err := file.WriteString(line + "\n"); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+
+	return file.Close()
+}
+
 func ReadLine(filename string) (string, error) {
 	content, err := os.ReadFile(filename)
 	if err != nil {
@@ -179,10 +283,19 @@ func ReadLine(filename string) (string, error) {
 }
 
 func GetRandomLine(filename string) (string, error) {
+	lines, err := ReadLines(filename)
+	if err != nil {
+		return "", err
+	}
+	return RandomLine(lines)
+}
+
+// ReadLines returns all of the non blank lines of a file
+func ReadLines(filename string) ([]string, error) {
 	// Read entire file
 	content, err := os.ReadFile(filename)
 	if err != nil {
-		return "", fmt.Errorf("failed to read file: %w", err)
+		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
 
 	// Split into lines
@@ -196,11 +309,31 @@ func GetRandomLine(filename string) (string, error) {
 		}
 	}
 
-	if len(nonEmptyLines) == 0 {
+	return nonEmptyLines, nil
+}
+
+func RandomLine(lines []string) (string, error) {
+	if len(lines) == 0 {
 		return "", fmt.Errorf("file is empty or contains only blank lines")
 	}
 
-	return nonEmptyLines[rand.Intn(len(nonEmptyLines))], nil
+	return lines[rand.Intn(len(lines))], nil
+}
+
+// HashFile returns the hex encoded sha256 of a files contents
+func HashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to hash file: %w", err)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
 func ClearCache() error {
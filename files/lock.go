@@ -0,0 +1,35 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// TryLock creates a lock file, returning false when another process already
+// holds it. A lock older than stale is assumed to be left behind by a process
+// that died and is taken over. The returned func releases the lock.
+func TryLock(path string, stale time.Duration) (func(), bool) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, false
+	}
+
+	for range 2 {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(path) }, true
+		}
+		if !os.IsExist(err) {
+			return nil, false
+		}
+
+		info, err := os.Stat(path)
+		if err == nil && time.Since(info.ModTime()) < stale {
+			return nil, false
+		}
+		os.Remove(path)
+	}
+
+	return nil, false
+}
@@ -0,0 +1,132 @@
+package files
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// QueryExt is the extension of the file holding the query for a cached result
+// set. The results themselves are stored next to it without an extension.
+const QueryExt = ".query"
+
+// QueueExt is the extension of the file listing wallpapers from a result set
+// that have been downloaded ready to be used next
+const QueueExt = ".queue"
+
+// LockExt is the extension of the file held while the prefetch queue of a
+// result set is being filled
+const LockExt = ".lock"
+
+// DeadExt is the extension of the file listing links from a result set that
+// no longer work
+const DeadExt = ".dead"
+
+type QueryEntry struct {
+	Provider string
+	Hash     string
+	Query    string
+	Path     string
+	Modified time.Time
+	Count    int
+}
+
+// QueryHash returns a short stable hash used to name a query's cache entry
+func QueryHash(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:8])
+}
+
+// QueryCachePath is the cache relative path of the result set for a query
+func QueryCachePath(provider string, query string) string {
+	return filepath.Join(provider, "queries", QueryHash(query))
+}
+
+// IsFresh reports whether the entry was written within expirySeconds
+func (q QueryEntry) IsFresh(expirySeconds int) bool {
+	return IsFileFresh(q.Path, expirySeconds)
+}
+
+// ListQueries returns every cached query result set under the cache dir,
+// most recently fetched first.
+func ListQueries(cacheDir string) ([]QueryEntry, error) {
+	matches, err := filepath.Glob(filepath.Join(cacheDir, "*", "queries", "*"+QueryExt))
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	var entries []QueryEntry
+	for _, queryFile := range matches {
+		query, err := os.ReadFile(queryFile)
+		if err != nil {
+			continue
+		}
+
+		path := strings.TrimSuffix(queryFile, QueryExt)
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+
+		count := 0
+		if content, err := os.ReadFile(path); err == nil {
+			for _, line := range strings.Split(string(content), "\n") {
+				if strings.TrimSpace(line) != "" {
+					count++
+				}
+			}
+		}
+
+		entries = append(entries, QueryEntry{
+			Provider: filepath.Base(filepath.Dir(filepath.Dir(path))),
+			Hash:     filepath.Base(path),
+			Query:    strings.TrimSpace(string(query)),
+			Path:     path,
+			Modified: info.ModTime(),
+			Count:    count,
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Modified.After(entries[j].Modified)
+	})
+
+	return entries, nil
+}
+
+// PruneQueries removes every cached result set older than expirySeconds and
+// returns the entries that were removed.
+func PruneQueries(cacheDir string, expirySeconds int) ([]QueryEntry, error) {
+	entries, err := ListQueries(cacheDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var pruned []QueryEntry
+	for _, entry := range entries {
+		if entry.IsFresh(expirySeconds) {
+			continue
+		}
+		if err := RemoveQuery(entry.Path); err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, entry)
+	}
+
+	return pruned, nil
+}
+
+// RemoveQuery deletes a result set along with its query and seen files
+func RemoveQuery(path string) error {
+	for _, file := range []string{path, path + QueryExt, path + SeenExt, path + DeadExt, path + QueueExt, path + LockExt} {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove cache entry: %w", err)
+		}
+	}
+	return nil
+}
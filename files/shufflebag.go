@@ -0,0 +1,67 @@
+package files
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SeenExt is the extension of the file tracking which lines of a cached result
+// set have already been used.
+const SeenExt = ".seen"
+
+// ShuffleBag tracks which candidates have been picked so that none repeat
+// until every candidate has been used. State is kept in a file so it persists
+// between runs.
+type ShuffleBag struct {
+	path string
+}
+
+func NewShuffleBag(path string) *ShuffleBag {
+	return &ShuffleBag{path: path}
+}
+
+// Unseen returns the candidates that have not been picked yet
+func (b *ShuffleBag) Unseen(candidates []string) []string {
+	seen := make(map[string]bool)
+	if lines, err := ReadLines(b.path); err == nil {
+		for _, line := range lines {
+			seen[line] = true
+		}
+	}
+
+	var unseen []string
+	for _, candidate := range candidates {
+		if !seen[candidate] {
+			unseen = append(unseen, candidate)
+		}
+	}
+	return unseen
+}
+
+// Mark records the candidate as picked
+func (b *ShuffleBag) Mark(candidate string) error {
+	if err := os.MkdirAll(filepath.Dir(b.path), 0755); err != nil {
+		return fmt.Errorf("failed to create directories: %w", err)
+	}
+
+	file, err := os.OpenFile(b.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(strings.TrimSpace(candidate) + "\n"); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return nil
+}
+
+// Reset forgets every picked candidate
+func (b *ShuffleBag) Reset() error {
+	if err := os.Remove(b.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to reset shuffle bag: %w", err)
+	}
+	return nil
+}
@@ -1,19 +1,120 @@
 package files
 
 import (
+	"context"
+	"fmt"
+	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 
-	"github.com/davenicholson-xyz/go-setwallpaper/wallpaper"
 	"github.com/davenicholson-xyz/wallmancer/download"
+	"github.com/davenicholson-xyz/wallmancer/setter"
 )
 
-func ApplyWallpaper(file string, provider string) (string, error) {
-	filename := filepath.Base(file)
-	cache_dir, _ := GetCacheDir()
-	output := filepath.Join(cache_dir, provider, filename)
+var ImageExtensions = []string{".jpg", ".jpeg", ".png", ".gif", ".bmp", ".webp"}
 
-	_ = download.DownloadImage(file, output)
-	wallpaper.Set(output)
+// IsImageFile checks the file extension against the supported image types
+func IsImageFile(path string) bool {
+	return slices.Contains(ImageExtensions, strings.ToLower(filepath.Ext(path)))
+}
+
+// IsRemote returns true if the file is a http(s) URL that needs downloading
+func IsRemote(file string) bool {
+	return strings.HasPrefix(file, "http://") || strings.HasPrefix(file, "https://")
+}
+
+// ApplyWallpaper sets the wallpaper with the given setter. Remote files are
+// downloaded into the providers cache dir first, size is their expected size
+// in bytes or 0 when it is not known.
+func ApplyWallpaper(ctx context.Context, client *download.Client, file string, provider string, size int64, s setter.Setter) (string, error) {
+	output, err := FetchWallpaper(ctx, client, file, provider, size)
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.Set(output); err != nil {
+		return "", err
+	}
+
+	return output, nil
+}
+
+// FetchWallpaper returns the absolute local path for a wallpaper, downloading
+// it into the providers cache dir if it is remote. A valid copy already in
+// the cache is used without downloading it again.
+func FetchWallpaper(ctx context.Context, client *download.Client, file string, provider string, size int64) (string, error) {
+	if !IsRemote(file) {
+		output, err := filepath.Abs(file)
+		if err != nil {
+			return "", fmt.Errorf("%w", err)
+		}
+		// Images from the cache, eg. from the history, count as used
+		if cache_dir, err := GetCacheDir(); err == nil && strings.HasPrefix(output, cache_dir+string(filepath.Separator)) {
+			Touch(output)
+		}
+		return output, nil
+	}
+
+	output, err := CachedImagePath(provider, file)
+	if err != nil {
+		return "", err
+	}
+
+	if isCached(output, size) {
+		Touch(output)
+		return output, nil
+	}
+
+	if err := client.DownloadImage(ctx, file, output, size); err != nil {
+		return "", fmt.Errorf("Could not download wallpaper: %w", err)
+	}
 
 	return output, nil
 }
+
+// CachedImagePath is where a remote image is kept in the providers cache dir
+func CachedImagePath(provider string, url string) (string, error) {
+	cache_dir, err := GetCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cache_dir, provider, filepath.Base(url)), nil
+}
+
+// IsImageCached reports whether a remote image has already been downloaded
+func IsImageCached(provider string, url string) bool {
+	path, err := CachedImagePath(provider, url)
+	return err == nil && PathExists(path)
+}
+
+// CachedImages lists the images that have been downloaded for a provider
+func CachedImages(provider string) ([]string, error) {
+	cache_dir, err := GetCacheDir()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(filepath.Join(cache_dir, provider))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read cache: %w", err)
+	}
+
+	var images []string
+	for _, entry := range entries {
+		if !entry.IsDir() && IsImageFile(entry.Name()) {
+			images = append(images, filepath.Join(cache_dir, provider, entry.Name()))
+		}
+	}
+
+	return images, nil
+}
+
+func isCached(path string, size int64) bool {
+	info, err := os.Stat(path)
+	if err != nil || (size > 0 && info.Size() != size) {
+		return false
+	}
+	_, err = download.ValidateImage(path)
+	return err == nil
+}
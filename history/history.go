@@ -0,0 +1,218 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrHistoryEmpty = errors.New("No wallpaper history")
+	ErrNoPrevious   = errors.New("Already at the oldest wallpaper in history")
+	ErrNoNext       = errors.New("Already at the newest wallpaper in history")
+)
+
+// Entry is a wallpaper that was applied. The entries for the outputs set
+// together in one multi monitor apply share a Time and Output is set.
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Provider string    `json:"provider"`
+	Source   string    `json:"source"`
+	Path     string    `json:"path"`
+	Query    string    `json:"query,omitempty"`
+	Output   string    `json:"output,omitempty"`
+}
+
+// History is a log of applied wallpapers stored as JSON lines, oldest first,
+// along with the position of the wallpaper currently shown.
+type History struct {
+	path     string
+	position string
+	max      int
+}
+
+func New(dir string, max int) *History {
+	return &History{
+		path:     filepath.Join(dir, "history.jsonl"),
+		position: filepath.Join(dir, "history_position"),
+		max:      max,
+	}
+}
+
+func (h *History) Entries() ([]Entry, error) {
+	file, err := os.Open(h.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+
+	return entries, nil
+}
+
+// Append adds an entry to the end of the history and makes it the current
+// position. The history is trimmed to the configured maximum length.
+func (h *History) Append(entry Entry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(h.path), 0755); err != nil {
+		return fmt.Errorf("failed to create history directory: %w", err)
+	}
+
+	file, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open history: %w", err)
+	}
+	_, err = file.Write(append(line, '\n'))
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+
+	if err := h.trim(); err != nil {
+		return err
+	}
+
+	if err := os.Remove(h.position); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to reset history position: %w", err)
+	}
+
+	return nil
+}
+
+// Position returns the index of the current wallpaper in Entries
+func (h *History) Position(entries []Entry) int {
+	content, err := os.ReadFile(h.position)
+	if err != nil {
+		return len(entries) - 1
+	}
+
+	pos, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil || pos < 0 || pos >= len(entries) {
+		return len(entries) - 1
+	}
+
+	return pos
+}
+
+// Previous steps back one apply and returns its entries
+func (h *History) Previous() ([]Entry, error) {
+	return h.step(-1)
+}
+
+// Next steps forward one apply and returns its entries
+func (h *History) Next() ([]Entry, error) {
+	return h.step(1)
+}
+
+// step moves to the apply before or after the current one. The position is
+// kept at the last entry of an apply.
+func (h *History) step(offset int) ([]Entry, error) {
+	entries, err := h.Entries()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(entries) == 0 {
+		return nil, ErrHistoryEmpty
+	}
+
+	start, end := group(entries, h.Position(entries))
+	if offset < 0 {
+		if start == 0 {
+			return nil, ErrNoPrevious
+		}
+		start, end = group(entries, start-1)
+	} else {
+		if end >= len(entries) {
+			return nil, ErrNoNext
+		}
+		start, end = group(entries, end)
+	}
+
+	if err := os.WriteFile(h.position, []byte(strconv.Itoa(end-1)), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write history position: %w", err)
+	}
+
+	return entries[start:end], nil
+}
+
+// group returns the range of entries applied together with entries[i]
+func group(entries []Entry, i int) (int, int) {
+	together := func(j int) bool {
+		return entries[j].Output != "" && entries[j].Time.Equal(entries[i].Time)
+	}
+
+	if !together(i) {
+		return i, i + 1
+	}
+
+	start, end := i, i+1
+	for start > 0 && together(start-1) {
+		start--
+	}
+	for end < len(entries) && together(end) {
+		end++
+	}
+	return start, end
+}
+
+func (h *History) trim() error {
+	if h.max <= 0 {
+		return nil
+	}
+
+	entries, err := h.Entries()
+	if err != nil {
+		return err
+	}
+
+	if len(entries) <= h.max {
+		return nil
+	}
+
+	var content strings.Builder
+	for _, entry := range entries[len(entries)-h.max:] {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+		content.Write(line)
+		content.WriteString("\n")
+	}
+
+	if err := os.WriteFile(h.path, []byte(content.String()), 0600); err != nil {
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+
+	return nil
+}
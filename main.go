@@ -1,87 +1,235 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"log/slog"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/davenicholson-xyz/go-cachetools/cachetools"
 	"github.com/davenicholson-xyz/wallmancer/appcontext"
+	"github.com/davenicholson-xyz/wallmancer/blacklist"
 	"github.com/davenicholson-xyz/wallmancer/config"
+	"github.com/davenicholson-xyz/wallmancer/control"
+	"github.com/davenicholson-xyz/wallmancer/download"
+	"github.com/davenicholson-xyz/wallmancer/favourites"
 	"github.com/davenicholson-xyz/wallmancer/files"
+	"github.com/davenicholson-xyz/wallmancer/history"
 	"github.com/davenicholson-xyz/wallmancer/providers"
+	"github.com/davenicholson-xyz/wallmancer/setter"
 )
 
 func main() {
 	slog.SetLogLoggerLevel(slog.LevelInfo)
-	result, err := runApp()
+	app := appcontext.NewAppContext()
+
+	// Interrupting cancels any requests in progress
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	sub, flgValues, positional := parseArgs(os.Args[1:])
+	name, result, err := runApp(ctx, app, sub, flgValues, positional)
+	code := printResult(ctx, app, outputFormat(app, flgValues), name, result, err)
+
+	// Let background work started by the daemon finish
+	app.Wait()
+	stop()
+
+	os.Exit(code)
+}
+
+// runApp runs the command chosen by the flags and returns its name with the
+// result
+func runApp(ctx context.Context, app *appcontext.AppContext, sub subcommand, flgValues map[string]any, positional []string) (string, string, error) {
+	switch {
+	case sub.args != nil:
+		if err := sub.args(flgValues, positional); err != nil {
+			return sub.name, "", err
+		}
+	case sub.run == nil && len(positional) > 0:
+		return "", "", fmt.Errorf("%w: unexpected argument %q", ErrUsage, positional[0])
+	}
+
+	cfg, err := loadConfig(flgValues)
 	if err != nil {
-		log.Println(err)
-		os.Exit(1)
+		return "", "", err
 	}
-	fmt.Println(result)
-}
+	app.AddConfig(cfg)
 
-func runApp() (string, error) {
-	app := appcontext.NewAppContext()
+	if sub.run != nil {
+		result, err := sub.run(cfg, positional)
+		return sub.name, result, err
+	}
+
+	if send := cfg.GetString("send"); send != "" {
+		command, arg, _ := strings.Cut(send, " ")
+		result, err := control.Send(cfg.GetStringWithDefault("socket", control.SocketPath()), command, arg)
+		return "send", result, err
+	}
+
+	ct, err := cachetools.New("wallmancer")
+	if err != nil {
+		return "", "", fmt.Errorf("Error creating cache: %w", err)
+	}
+
+	app.AddCacheTools(ct)
+	if err := applyConfig(app, cfg); err != nil {
+		return "", "", err
+	}
+
+	dataDir, err := files.GetDataDir()
+	if err != nil {
+		return "", "", fmt.Errorf("Error creating data directory: %w", err)
+	}
+	app.AddFavourites(favourites.New(filepath.Join(dataDir, "favourites")))
+	app.AddBlacklist(blacklist.New(dataDir))
+
+	// Started by an earlier run to prefetch wallpapers after it exited
+	if job := os.Getenv(providers.PrefetchEnv); job != "" {
+		return "prefetch", "", providers.RunPrefetch(ctx, app, job)
+	}
 
-	flg := config.NewFlagSet()
+	if app.Config.GetBool("daemon") {
+		result, err := runDaemon(ctx, app, flgValues)
+		return "daemon", result, err
+	}
+
+	cmd := selectCommand(app)
+	result, err := cmd.run(ctx, app)
+	return cmd.name, result, err
+}
 
+// defineGlobalFlags defines the flags taken by every command
+func defineGlobalFlags(flg *config.FlagSet) {
 	flg.DefineString("provider", "", "wallpaper provider")
 	flg.DefineString("username", "", "wallhaven.cc username")
 	flg.DefineString("apikey", "", "wallhaven.cc api key")
 	flg.DefineBool("nsfw", false, "Fetch NSFW images")
+	flg.DefineString("setter", "", "wallpaper setter (auto, default, feh, swaybg, hyprpaper, gnome, xwallpaper, command)")
+	flg.DefineString("setter_command", "", "command for the command setter, {IMG} is replaced with the image path")
+	flg.DefineBool("auto_fit", false, "only use wallpapers that fit the screen size")
+	flg.DefineString("screen", "", "screen size for auto_fit (eg. 3840x2160), detected when not set")
+	flg.DefineBool("multi_monitor", false, "set a different wallpaper on each output")
+	flg.DefineString("local_dirs", "", "comma separated directories for the local provider")
 	flg.DefineInt("expiry", 0, "cache expiry in seconds")
+	flg.DefineString("selection", "", "how to pick from results (random or shuffle)")
+	flg.DefineInt("concurrency", 0, "number of pages to fetch in parallel")
+	flg.DefineString("socket", "", "path of the daemon control socket")
+	flg.DefineInt("history_size", 0, "maximum number of wallpapers kept in history")
+	flg.DefineString("output", "", "output format (text or json)")
+	flg.DefineBool("json", false, "same as -output json (deprecated)")
+	flg.DefineBool("offline", false, "only use cached results and downloaded wallpapers")
+	flg.DefineString("proxy", "", "proxy URL for requests, HTTP(S)_PROXY is used when not set")
+}
 
-	flg.DefineBool("clear", false, "clear the wallmancer cache")
-
-	flg.DefineString("random", "", "query for random wallpaper")
-	flg.DefineBool("hot", false, "hot")
-	flg.DefineBool("top", false, "toplist")
+// defineSearchFlags defines the wallhaven search filters
+func defineSearchFlags(flg *config.FlagSet) {
 	flg.DefineString("seed", "", "random seed for search")
+	flg.DefineString("categories", "", "categories to search (eg. 110 or general,anime)")
+	flg.DefineString("atleast", "", "minimum resolution (eg. 1920x1080)")
+	flg.DefineString("resolutions", "", "exact resolutions (eg. 1920x1080,2560x1440)")
+	flg.DefineString("ratios", "", "aspect ratios (eg. 16x9,16x10 or landscape)")
+	flg.DefineString("colors", "", "colour to search for (eg. 660000)")
+	flg.DefineString("order", "", "sort order (desc or asc)")
+	flg.DefineBool("ai_art_filter", false, "filter out AI generated art")
+}
 
-	flgValues := flg.Collect()
-
-	default_cfg_path, exists := files.DefaultConfigFilepath()
-	cfg, err := config.New(default_cfg_path)
-	cfg.FlagOverride(flgValues)
+func defineCacheFlags(flg *config.FlagSet) {
+	flg.DefineString("max_cache_size", "", "evict downloaded images beyond this size (eg. 500MB)")
+	flg.DefineString("max_cache_age", "", "evict downloaded images not used for this long (eg. 30d)")
+}
 
-	app.AddConfig(cfg)
+func defineDaemonFlags(flg *config.FlagSet) {
+	flg.DefineBool("daemon", false, "keep running and rotate wallpapers every interval")
+	flg.DefineString("interval", "", "time between wallpaper changes in daemon mode (eg. 30m)")
+}
 
-	flagstring := fmt.Sprintf("%v+", app.Config)
-	slog.Info(flagstring)
+// defineLegacyFlags defines the flags used without a subcommand. Those
+// replaced by a subcommand are kept working as deprecated aliases.
+func defineLegacyFlags(flg *config.FlagSet) {
+	defineDaemonFlags(flg)
+	flg.DefineString("send", "", "send a command to the running daemon (next, previous, pause, resume, favourite, ban, status, set-query <query>)")
+
+	flg.DefineBool("clear", false, "clear the wallmancer cache (deprecated: cache clear)")
+	flg.DefineBool("queries", false, "list cached query results (deprecated: cache list)")
+	flg.DefineBool("prune", false, "remove expired query results and evict old images from the cache (deprecated: cache prune)")
+	defineCacheFlags(flg)
+
+	flg.DefineBool("previous", false, "apply the previous wallpaper from history")
+	flg.DefineBool("next", false, "apply the next wallpaper from history")
+	flg.DefineBool("history", false, "list wallpaper history (deprecated: history)")
+
+	flg.DefineBool("favourite", false, "save the current wallpaper to favourites")
+	flg.DefineBool("unfavourite", false, "remove the current wallpaper from favourites")
+	flg.DefineBool("favourites", false, "list favourite wallpapers")
+	flg.DefineBool("ban", false, "blacklist the current wallpaper")
+	flg.DefineBool("info", false, "show details of the current wallpaper (deprecated: info)")
+
+	flg.DefineString("random", "", "query for random wallpaper (deprecated: random <query>)")
+	flg.DefineBool("hot", false, "hot (deprecated: hot)")
+	flg.DefineBool("top", false, "toplist (deprecated: top)")
+	flg.DefineString("topRange", "", "toplist time range (1d, 3d, 1w, 1M, 3M, 6M, 1y)")
+	defineSearchFlags(flg)
+}
 
-	if err != nil {
-		return "", fmt.Errorf("Failed to load config: %w", err)
+// outputFormat is the output format from the config, or from the flags when
+// the command failed before the config was loaded
+func outputFormat(app *appcontext.AppContext, flgValues map[string]any) string {
+	if app.Config != nil {
+		return app.Config.GetString("output")
 	}
+	format, _ := flgValues["output"].(string)
+	return format
+}
 
-	ct, err := cachetools.New("wallmancer")
+// loadConfig reads the config file and applies the command line flags on top
+func loadConfig(flgValues map[string]any) (*config.Config, error) {
+	default_cfg_path, _ := files.DefaultConfigFilepath()
+	cfg, err := config.New(default_cfg_path)
 	if err != nil {
-		return "", fmt.Errorf("Error creating cache: %w", err)
+		return nil, fmt.Errorf("Failed to load config: %w", err)
 	}
+	cfg.FlagOverride(flgValues)
 
-	app.AddCacheTools(ct)
+	flagstring := fmt.Sprintf("%v+", cfg)
+	slog.Info(flagstring)
 
-	if app.Config.GetBool("clear") {
-		slog.Info("Clearing the cache")
-		err := app.CacheTools.Clear()
-		if err != nil {
-			return "", fmt.Errorf("Error deleting cache: %w", err)
-		}
-		return "Cache deleted", nil
+	return cfg, nil
+}
+
+// applyConfig sets the config on the app along with anything built from it
+func applyConfig(app *appcontext.AppContext, cfg *config.Config) error {
+	s, err := setter.New(cfg.GetString("setter"), cfg.GetString("setter_command"))
+	if err != nil {
+		return fmt.Errorf("Failed to load config: %w", err)
 	}
+	slog.Info("Using wallpaper setter", "setter", s.Name())
 
-	prov := app.Config.GetStringWithDefault("provider", "wallhaven")
-	provider, exists := providers.GetProvider(prov)
-	if !exists {
-		return "", fmt.Errorf("Provider error: %w", err)
+	var retries *int
+	if _, ok := cfg.Get("retries"); ok {
+		n := cfg.GetInt("retries")
+		retries = &n
 	}
 
-	result, err := provider.ParseArgs(app)
+	client, err := download.NewClient(download.ClientOptions{
+		Timeout:         time.Duration(cfg.GetInt("timeout")) * time.Second,
+		DownloadTimeout: time.Duration(cfg.GetInt("download_timeout")) * time.Second,
+		Proxy:           cfg.GetString("proxy"),
+		UserAgent:       cfg.GetString("user_agent"),
+		Retries:         retries,
+		RateLimit:       cfg.GetInt("rate_limit"),
+	})
 	if err != nil {
-		return "", fmt.Errorf("%w", err)
+		return fmt.Errorf("Failed to load config: %w", err)
 	}
 
-	return result, nil
+	app.AddConfig(cfg)
+	app.AddHistory(history.New(app.CacheTools.Join(""), app.Config.GetIntWithDefault("history_size", 100)))
+	app.AddSetter(s)
+	app.AddClient(client)
+
+	return nil
 }
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/davenicholson-xyz/wallmancer/appcontext"
+	"github.com/davenicholson-xyz/wallmancer/blacklist"
+	"github.com/davenicholson-xyz/wallmancer/config"
+	"github.com/davenicholson-xyz/wallmancer/download"
+	"github.com/davenicholson-xyz/wallmancer/files"
+	"github.com/davenicholson-xyz/wallmancer/providers"
+	"github.com/davenicholson-xyz/wallmancer/setter"
+)
+
+// Exit codes, documented in the README
+const (
+	ExitOK        = 0
+	ExitError     = 1
+	ExitConfig    = 2
+	ExitNetwork   = 3
+	ExitNoResults = 4
+	ExitSetter    = 5
+)
+
+// Report is the document printed for every command with -output json
+type Report struct {
+	OK        bool                        `json:"ok"`
+	Command   string                      `json:"command"`
+	Result    string                      `json:"result,omitempty"`
+	Cache     string                      `json:"cache,omitempty"`
+	Wallpaper *providers.CurrentWallpaper `json:"wallpaper,omitempty"`
+	Items     any                         `json:"items,omitempty"`
+	Error     *ReportError                `json:"error,omitempty"`
+}
+
+type ReportError struct {
+	Code    string `json:"code"`
+	Exit    int    `json:"exit"`
+	Message string `json:"message"`
+}
+
+// errorCode sorts an error into one of the documented failure kinds
+func errorCode(err error) (string, int) {
+	switch {
+	case errors.Is(err, download.ErrNetwork),
+		errors.Is(err, download.ErrNotFound),
+		errors.Is(err, download.ErrInvalidImage),
+		errors.Is(err, download.ErrIncomplete),
+		errors.Is(err, providers.ErrOffline):
+		return "network", ExitNetwork
+	case errors.Is(err, setter.ErrUnknownSetter),
+		errors.Is(err, providers.ErrUnknownProvider),
+		errors.Is(err, providers.ErrInvalidFilter),
+		errors.Is(err, providers.ErrInvalidScreen),
+		errors.Is(err, providers.ErrNoLocalDirs),
+		errors.Is(err, ErrInvalidInterval),
+		errors.Is(err, ErrUsage),
+		errors.Is(err, blacklist.ErrEmptyTag),
+		errors.Is(err, files.ErrInvalidSize),
+		errors.Is(err, files.ErrInvalidAge),
+		errors.Is(err, download.ErrInvalidProxy),
+		errors.Is(err, download.ErrHTTPStatus),
+		errors.Is(err, config.ErrKeyNotSet):
+		return "config", ExitConfig
+	case errors.Is(err, providers.ErrNoWallpapers),
+		errors.Is(err, providers.ErrNoFit),
+		errors.Is(err, providers.ErrNoCandidates),
+		errors.Is(err, ErrNoCachedQuery):
+		return "no_results", ExitNoResults
+	case errors.Is(err, setter.ErrSetFailed),
+		errors.Is(err, setter.ErrNoOutputs):
+		return "setter", ExitSetter
+	}
+	return "error", ExitError
+}
+
+// printResult prints the result of a command in format and returns the exit
+// code
+func printResult(ctx context.Context, app *appcontext.AppContext, format string, name string, result string, err error) int {
+	if format != "json" {
+		if err != nil {
+			log.Println(err)
+			_, exit := errorCode(err)
+			return exit
+		}
+		fmt.Println(result)
+		return ExitOK
+	}
+
+	report := buildReport(ctx, app, name, result, err)
+
+	data, jsonErr := json.MarshalIndent(report, "", "  ")
+	if jsonErr != nil {
+		log.Println(jsonErr)
+		return ExitError
+	}
+	fmt.Println(string(data))
+
+	if report.Error != nil {
+		return report.Error.Exit
+	}
+	return ExitOK
+}
+
+func buildReport(ctx context.Context, app *appcontext.AppContext, name string, result string, err error) Report {
+	report := Report{OK: err == nil, Command: name, Cache: app.CacheStatus}
+
+	if err != nil {
+		code, exit := errorCode(err)
+		report.Error = &ReportError{Code: code, Exit: exit, Message: err.Error()}
+		return report
+	}
+
+	report.Result = result
+
+	switch name {
+	case "apply", "previous", "next", "favourite", "unfavourite", "ban", "info":
+		if current, err := providers.Current(app); err == nil {
+			if name == "info" {
+				if id := providers.WallhavenID(current); id != "" {
+					current.Wallpaper, _ = providers.WallhavenInfo(ctx, app, id)
+				}
+				report.Result = ""
+			}
+			report.Wallpaper = &current
+		}
+	case "history":
+		report.Items = items(app.History.Entries())
+	case "favourites":
+		report.Items = items(app.Favourites.List())
+	case "banned_tags":
+		report.Items = items(app.Blacklist.Tags(), nil)
+	case "queries":
+		report.Items = items(files.ListQueries(app.CacheTools.Join("")))
+	case "stats":
+		report.Items = items(collectCacheStats(app))
+	case "show":
+		report.Items = items(matchQueries(app, app.Config.GetString("show_query")))
+	}
+
+	return report
+}
+
+// items keeps list results as a JSON array even when they are empty
+func items[T any](list []T, _ error) []T {
+	if list == nil {
+		return []T{}
+	}
+	return list
+}
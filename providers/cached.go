@@ -0,0 +1,63 @@
+package providers
+
+import (
+	"fmt"
+
+	"github.com/davenicholson-xyz/wallmancer/files"
+)
+
+// CachedResult is a wallpaper stored in a cached result set along with what
+// has been done with it
+type CachedResult struct {
+	Wallpaper
+	Seen       bool `json:"seen"`
+	Queued     bool `json:"queued"`
+	Downloaded bool `json:"downloaded"`
+	Dead       bool `json:"dead"`
+}
+
+// CachedResults lists the wallpapers stored for a cached query. Dead links
+// that have been removed from the result set are listed at the end.
+func CachedResults(entry files.QueryEntry) ([]CachedResult, error) {
+	results, err := ReadResults(entry.Path)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	seen := make(map[string]bool)
+	if lines, err := files.ReadLines(entry.Path + files.SeenExt); err == nil {
+		for _, line := range lines {
+			seen[line] = true
+		}
+	}
+
+	queue := make(map[string]bool)
+	for _, q := range readQueue(entry.Path) {
+		queue[q.Wallpaper.Path] = true
+	}
+
+	dead := deadLinks(entry.Path)
+
+	var cached []CachedResult
+	for _, wp := range results {
+		cached = append(cached, CachedResult{
+			Wallpaper:  wp,
+			Seen:       seen[wp.Path],
+			Queued:     queue[wp.Path],
+			Downloaded: files.IsImageCached(entry.Provider, wp.Path),
+			Dead:       dead[wp.Path],
+		})
+		delete(dead, wp.Path)
+	}
+
+	if lines, err := files.ReadLines(entry.Path + files.DeadExt); err == nil {
+		for _, line := range lines {
+			if dead[line] {
+				cached = append(cached, CachedResult{Wallpaper: Wallpaper{Path: line}, Dead: true})
+				delete(dead, line)
+			}
+		}
+	}
+
+	return cached, nil
+}
@@ -0,0 +1,101 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/davenicholson-xyz/wallmancer/appcontext"
+	"github.com/davenicholson-xyz/wallmancer/files"
+)
+
+// CurrentWallpaper is the record kept in each providers current file
+type CurrentWallpaper struct {
+	Provider  string     `json:"provider"`
+	Source    string     `json:"source"`
+	Path      string     `json:"path"`
+	Query     string     `json:"query,omitempty"`
+	Output    string     `json:"output,omitempty"`
+	Applied   time.Time  `json:"applied"`
+	Wallpaper *Wallpaper `json:"wallpaper,omitempty"`
+}
+
+func currentFromSelection(sel Selection, path string) CurrentWallpaper {
+	return CurrentWallpaper{
+		Provider:  sel.Provider,
+		Source:    sel.Source,
+		Path:      path,
+		Query:     sel.Query,
+		Wallpaper: sel.Wallpaper,
+	}
+}
+
+// Current returns the wallpaper applied last, whichever provider it came
+// from. It is not always the configured provider, eg. after -provider or
+// going back through the history.
+func Current(app *appcontext.AppContext) (CurrentWallpaper, error) {
+	currents, _ := filepath.Glob(app.CacheTools.Join(filepath.Join("*", "current")))
+
+	var latest CurrentWallpaper
+	for _, filename := range currents {
+		current, err := readCurrent(filename, filepath.Base(filepath.Dir(filename)))
+		if err != nil {
+			continue
+		}
+		if latest.Path == "" || current.Applied.After(latest.Applied) {
+			latest = current
+		}
+	}
+
+	if latest.Path == "" {
+		return CurrentWallpaper{}, ErrNoCurrent
+	}
+	return latest, nil
+}
+
+func readCurrent(filename string, provider string) (CurrentWallpaper, error) {
+	content, err := files.ReadLine(filename)
+	if err != nil || strings.TrimSpace(content) == "" {
+		return CurrentWallpaper{}, fmt.Errorf("%w for provider %s", ErrNoCurrent, provider)
+	}
+
+	var current CurrentWallpaper
+	if err := json.Unmarshal([]byte(content), &current); err != nil {
+		// Older current files hold the source and path on separate lines
+		source, path, _ := strings.Cut(strings.TrimSpace(content), "\n")
+		current = CurrentWallpaper{Provider: provider, Source: source, Path: path}
+	}
+
+	if current.Source == "" || current.Path == "" {
+		return CurrentWallpaper{}, fmt.Errorf("%w for provider %s", ErrNoCurrent, provider)
+	}
+
+	// Older current files have no applied time
+	if current.Applied.IsZero() {
+		if info, err := os.Stat(filename); err == nil {
+			current.Applied = info.ModTime()
+		}
+	}
+
+	return current, nil
+}
+
+func writeCurrent(app *appcontext.AppContext, current CurrentWallpaper) error {
+	if current.Applied.IsZero() {
+		current.Applied = time.Now()
+	}
+
+	data, err := json.MarshalIndent(current, "", "  ")
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	err = app.CacheTools.WriteStringToFile(filepath.Join(current.Provider, "current"), string(data))
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package providers
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+
+	"github.com/davenicholson-xyz/wallmancer/appcontext"
+	"github.com/davenicholson-xyz/wallmancer/files"
+)
+
+// EvictCache removes downloaded images once they take up more than
+// max_cache_size or have not been used for max_cache_age. The current
+// wallpapers, favourites and prefetched wallpapers are always kept.
+func EvictCache(app *appcontext.AppContext) ([]files.CachedImage, error) {
+	maxBytes, err := files.ParseSize(app.Config.GetString("max_cache_size"))
+	if err != nil {
+		return nil, fmt.Errorf("max_cache_size: %w", err)
+	}
+
+	maxAge, err := files.ParseAge(app.Config.GetString("max_cache_age"))
+	if err != nil {
+		return nil, fmt.Errorf("max_cache_age: %w", err)
+	}
+
+	if maxBytes == 0 && maxAge == 0 {
+		return nil, nil
+	}
+
+	return files.EvictImages(app.CacheTools.Join(""), maxBytes, maxAge, keptImages(app))
+}
+
+// keptImages are the images that must not be evicted
+func keptImages(app *appcontext.AppContext) map[string]bool {
+	keep := make(map[string]bool)
+	add := func(path string) {
+		if path != "" {
+			keep[filepath.Clean(path)] = true
+		}
+	}
+
+	cacheDir := app.CacheTools.Join("")
+
+	// The current wallpaper of every provider
+	currents, _ := filepath.Glob(filepath.Join(cacheDir, "*", "current"))
+	for _, filename := range currents {
+		if current, err := readCurrent(filename, filepath.Base(filepath.Dir(filename))); err == nil {
+			add(current.Path)
+		}
+	}
+
+	// The latest wallpaper on each output
+	if app.History != nil {
+		entries, _ := app.History.Entries()
+		outputs := make(map[string]bool)
+		for i := len(entries) - 1; i >= 0; i-- {
+			if !outputs[entries[i].Output] {
+				outputs[entries[i].Output] = true
+				add(entries[i].Path)
+			}
+		}
+	}
+
+	if app.Favourites != nil {
+		favs, _ := app.Favourites.List()
+		for _, fav := range favs {
+			add(fav.Path)
+			if files.IsRemote(fav.Source) {
+				if path, err := files.CachedImagePath(fav.Provider, fav.Source); err == nil {
+					add(path)
+				}
+			}
+		}
+	}
+
+	queues, _ := filepath.Glob(filepath.Join(cacheDir, "*", "queries", "*"+files.QueueExt))
+	for _, queue := range queues {
+		for _, q := range readQueue(strings.TrimSuffix(queue, files.QueueExt)) {
+			add(q.File)
+		}
+	}
+
+	return keep
+}
+
+// evictAfterApply runs EvictCache, only logging problems so the wallpaper
+// that was just applied is not reported as failing
+func evictAfterApply(app *appcontext.AppContext) {
+	removed, err := EvictCache(app)
+	if err != nil {
+		slog.Warn("Could not evict cached images", "error", err)
+		return
+	}
+	if len(removed) > 0 {
+		slog.Info("Evicted cached images", "count", len(removed))
+	}
+}
@@ -0,0 +1,95 @@
+package providers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/davenicholson-xyz/wallmancer/appcontext"
+	"github.com/davenicholson-xyz/wallmancer/download"
+	"github.com/davenicholson-xyz/wallmancer/files"
+)
+
+var (
+	ErrAllFailed = errors.New("No wallpaper could be applied")
+)
+
+// fallbackAttempts is how many candidates are tried before giving up
+func fallbackAttempts(app *appcontext.AppContext) int {
+	return max(app.Config.GetIntWithDefault("fallback_attempts", 5), 1)
+}
+
+// tryCandidates selects wallpapers from the provider and passes them to
+// apply until one works. Failed wallpapers are skipped for the rest of the
+// run and links that are dead are removed from the cache.
+func tryCandidates(app *appcontext.AppContext, selectFn func() (Selection, error), apply func(sel Selection) error) (Selection, error) {
+	attempts := fallbackAttempts(app)
+
+	var lastErr error
+	for attempt := range attempts {
+		sel, err := selectFn()
+		if err != nil {
+			if lastErr != nil {
+				return Selection{}, fmt.Errorf("%w after %d attempts: %w", ErrAllFailed, attempt, lastErr)
+			}
+			return Selection{}, err
+		}
+
+		if sel.IsEmpty() {
+			return sel, nil
+		}
+
+		err = apply(sel)
+		if err == nil {
+			return sel, nil
+		}
+
+		slog.Warn("Could not apply wallpaper, trying another", "wallpaper", sel.Source, "error", err)
+		lastErr = err
+		app.Skip(sel.File)
+
+		if isDeadLink(err) && files.IsRemote(sel.File) {
+			if err := markDead(sel); err != nil {
+				slog.Warn("Could not record dead link", "error", err)
+			}
+		}
+	}
+
+	return Selection{}, fmt.Errorf("%w after %d attempts: %w", ErrAllFailed, attempts, lastErr)
+}
+
+// isDeadLink reports whether a failure means the wallpaper will never work,
+// not that it failed this time
+func isDeadLink(err error) bool {
+	return errors.Is(err, download.ErrNotFound) || errors.Is(err, download.ErrInvalidImage)
+}
+
+// markDead records a dead link next to the result set it was picked from so
+// it is left out from then on. The result set itself is not rewritten, that
+// would make it look freshly fetched. Lines are only ever appended so the
+// prefetch goroutine and the fallback can both record dead links.
+func markDead(sel Selection) error {
+	if sel.Results == "" {
+		return nil
+	}
+
+	slog.Info("Recording dead link", "wallpaper", sel.File)
+
+	return files.AppendLine(sel.Results+files.DeadExt, sel.File)
+}
+
+// deadLinks returns the dead links recorded for a result set
+func deadLinks(results string) map[string]bool {
+	dead := make(map[string]bool)
+
+	lines, err := files.ReadLines(results + files.DeadExt)
+	if err != nil {
+		return dead
+	}
+
+	for _, line := range lines {
+		dead[line] = true
+	}
+
+	return dead
+}
@@ -0,0 +1,60 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/davenicholson-xyz/wallmancer/appcontext"
+	"github.com/davenicholson-xyz/wallmancer/favourites"
+)
+
+type FavouritesProvider struct{}
+
+func (f *FavouritesProvider) Name() string {
+	return "favourites"
+}
+
+func (f *FavouritesProvider) Select(ctx context.Context, app *appcontext.AppContext) (Selection, error) {
+	favs, err := app.Favourites.List()
+	if err != nil {
+		return Selection{}, fmt.Errorf("%w", err)
+	}
+
+	if len(favs) == 0 {
+		return Selection{}, favourites.ErrNoFavourites
+	}
+
+	query := app.Config.GetString("random")
+	if query != "" {
+		var matched []favourites.Favourite
+		for _, fav := range favs {
+			if len(matchQuery([]string{fav.Path}, query)) > 0 {
+				matched = append(matched, fav)
+			}
+		}
+		favs = matched
+	}
+
+	if len(favs) == 0 {
+		return Selection{}, ErrNoWallpapers
+	}
+
+	byPath := make(map[string]favourites.Favourite)
+	var paths []string
+	for _, fav := range favs {
+		if app.Blacklist != nil && app.Blacklist.IsBanned(fav.Source) {
+			continue
+		}
+		byPath[fav.Path] = fav
+		paths = append(paths, fav.Path)
+	}
+
+	path, err := selectCandidate(app, paths, app.CacheTools.Join(filepath.Join(f.Name(), "seen")))
+	if err != nil {
+		return Selection{}, err
+	}
+	selected := byPath[path]
+
+	return Selection{Provider: f.Name(), Source: selected.Source, File: selected.Path, Query: query}, nil
+}
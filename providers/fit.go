@@ -0,0 +1,103 @@
+package providers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/davenicholson-xyz/wallmancer/appcontext"
+	"github.com/davenicholson-xyz/wallmancer/setter"
+)
+
+var (
+	ErrNoFit         = errors.New("No wallpapers fit the screen")
+	ErrInvalidScreen = errors.New("Invalid screen size")
+)
+
+// defaultRatioTolerance allows a 16:10 image on a 16:9 screen but not 4:3
+const defaultRatioTolerance = 0.12
+
+// fitScreen keeps the wallpapers that are at least as large as the screen
+// and close to its aspect ratio. Results without dimensions are kept.
+func fitScreen(app *appcontext.AppContext, results []Wallpaper) ([]Wallpaper, error) {
+	width, height, err := screenSize(app)
+	if err != nil {
+		return nil, err
+	}
+
+	tolerance := defaultRatioTolerance
+	if val := app.Config.GetString("ratio_tolerance"); val != "" {
+		if t, err := strconv.ParseFloat(val, 64); err == nil {
+			tolerance = t
+		}
+	}
+
+	screenRatio := float64(width) / float64(height)
+
+	var fitted []Wallpaper
+	for _, wp := range results {
+		if wp.DimensionX == 0 || wp.DimensionY == 0 {
+			fitted = append(fitted, wp)
+			continue
+		}
+
+		if wp.DimensionX < width || wp.DimensionY < height {
+			continue
+		}
+
+		ratio := float64(wp.DimensionX) / float64(wp.DimensionY)
+		if math.Abs(ratio-screenRatio)/screenRatio > tolerance {
+			continue
+		}
+
+		fitted = append(fitted, wp)
+	}
+
+	slog.Info("Filtered results to fit screen", "screen", fmt.Sprintf("%dx%d", width, height), "results", len(results), "fitted", len(fitted))
+
+	if len(fitted) == 0 {
+		return nil, fmt.Errorf("%w (%dx%d)", ErrNoFit, width, height)
+	}
+
+	return fitted, nil
+}
+
+// screenSize uses the configured screen size (eg. 3840x2160) or the largest
+// detected output
+func screenSize(app *appcontext.AppContext) (int, int, error) {
+	if screen := app.Config.GetString("screen"); screen != "" {
+		return parseSize(screen)
+	}
+
+	outputs, err := setter.Outputs()
+	if err != nil {
+		return 0, 0, fmt.Errorf("Could not detect screen size, set screen in the config: %w", err)
+	}
+
+	var width, height int
+	for _, output := range outputs {
+		if output.Width*output.Height > width*height {
+			width, height = output.Width, output.Height
+		}
+	}
+
+	return width, height, nil
+}
+
+func parseSize(size string) (int, int, error) {
+	w, h, ok := strings.Cut(strings.ToLower(size), "x")
+	if !ok {
+		return 0, 0, fmt.Errorf("%w: %s", ErrInvalidScreen, size)
+	}
+
+	width, errW := strconv.Atoi(strings.TrimSpace(w))
+	height, errH := strconv.Atoi(strings.TrimSpace(h))
+	if errW != nil || errH != nil || width <= 0 || height <= 0 {
+		return 0, 0, fmt.Errorf("%w: %s", ErrInvalidScreen, size)
+	}
+
+	return width, height, nil
+}
@@ -0,0 +1,122 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/davenicholson-xyz/wallmancer/appcontext"
+	"github.com/davenicholson-xyz/wallmancer/files"
+)
+
+var (
+	ErrNoLocalDirs = errors.New("No directories configured for local provider (local_dirs)")
+)
+
+type LocalProvider struct{}
+
+func (l *LocalProvider) Name() string {
+	return "local"
+}
+
+func (l *LocalProvider) Select(ctx context.Context, app *appcontext.AppContext) (Selection, error) {
+	dirs := app.Config.GetStringSlice("local_dirs")
+	if len(dirs) == 0 {
+		return Selection{}, ErrNoLocalDirs
+	}
+
+	images, err := scanDirs(dirs)
+	if err != nil {
+		return Selection{}, err
+	}
+
+	query := app.Config.GetString("random")
+	if query != "" {
+		images = matchQuery(images, query)
+	}
+
+	if len(images) == 0 {
+		return Selection{}, ErrNoWallpapers
+	}
+
+	bag := filepath.Join(l.Name(), "seen", files.QueryHash(strings.Join(dirs, ",")+"|"+query))
+	selected, err := selectCandidate(app, images, app.CacheTools.Join(bag))
+	if err != nil {
+		return Selection{}, err
+	}
+
+	return Selection{Provider: l.Name(), Source: selected, File: selected, Query: query}, nil
+}
+
+// scanDirs recursively collects every image file in the given directories
+func scanDirs(dirs []string) ([]string, error) {
+	var images []string
+
+	for _, dir := range dirs {
+		dir = expandHome(dir)
+		slog.Info("Scanning local directory", "dir", dir)
+
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && files.IsImageFile(path) {
+				images = append(images, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Could not scan directory %s: %w", dir, err)
+		}
+	}
+
+	return images, nil
+}
+
+// matchQuery filters images by query. Queries containing glob characters are
+// matched against the filename, otherwise every word of the query must appear
+// somewhere in the path.
+func matchQuery(images []string, query string) []string {
+	query = strings.ToLower(query)
+	isGlob := strings.ContainsAny(query, "*?[")
+	terms := strings.Fields(query)
+
+	var matched []string
+	for _, image := range images {
+		path := strings.ToLower(image)
+
+		if isGlob {
+			if ok, _ := filepath.Match(query, filepath.Base(path)); ok {
+				matched = append(matched, image)
+			}
+			continue
+		}
+
+		all := true
+		for _, term := range terms {
+			if !strings.Contains(path, term) {
+				all = false
+				break
+			}
+		}
+		if all {
+			matched = append(matched, image)
+		}
+	}
+
+	return matched
+}
+
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, strings.TrimPrefix(path, "~"))
+		}
+	}
+	return path
+}
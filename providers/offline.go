@@ -0,0 +1,58 @@
+package providers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/davenicholson-xyz/wallmancer/appcontext"
+	"github.com/davenicholson-xyz/wallmancer/files"
+)
+
+var (
+	ErrOffline = errors.New("Not available offline")
+)
+
+// isOffline reports whether the network should not be used
+func isOffline(app *appcontext.AppContext) bool {
+	return app.Config.GetBool("offline")
+}
+
+// selectOffline picks a wallpaper that has already been downloaded. Results
+// cached for the query are used whatever their age, failing that any image in
+// the providers cache dir is used.
+func (w *WallhavenProvider) selectOffline(app *appcontext.AppContext, outfile string) (Selection, error) {
+	app.SetCacheStatus("offline")
+	results := app.CacheTools.Join(outfile)
+
+	if info, err := os.Stat(results); err == nil {
+		selected, err := selectFromResultsWhere(app, results, func(wp Wallpaper) bool {
+			return files.IsImageCached(w.Name(), wp.Path)
+		})
+		if err == nil {
+			slog.Warn("Offline, using cached results", "query", queryLabel(app), "age", time.Since(info.ModTime()).Round(time.Second))
+			return w.selection(app, selected, results), nil
+		}
+		if !errors.Is(err, ErrNoWallpapers) {
+			return Selection{}, err
+		}
+	}
+
+	images, err := files.CachedImages(w.Name())
+	if err != nil {
+		return Selection{}, err
+	}
+	if len(images) == 0 {
+		return Selection{}, fmt.Errorf("%w: no wallpapers have been downloaded", ErrOffline)
+	}
+
+	selected, err := selectCandidate(app, images, "")
+	if err != nil {
+		return Selection{}, err
+	}
+
+	slog.Warn("Offline, no cached results for the query, using a downloaded wallpaper", "query", queryLabel(app))
+	return Selection{Provider: w.Name(), Source: selected, File: selected, Query: queryLabel(app)}, nil
+}
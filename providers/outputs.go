@@ -0,0 +1,132 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/davenicholson-xyz/wallmancer/appcontext"
+	"github.com/davenicholson-xyz/wallmancer/files"
+	"github.com/davenicholson-xyz/wallmancer/setter"
+)
+
+// distinctAttempts is how many times a provider is asked for a wallpaper that
+// is not already shown on another output
+const distinctAttempts = 5
+
+// ApplyOutputs picks a different wallpaper for each output and sets them all
+// together. Outputs can be pinned to a provider or query in the outputs
+// section of the config.
+func ApplyOutputs(ctx context.Context, app *appcontext.AppContext, outputs []setter.Output) (string, error) {
+	pins := app.Config.GetSection("outputs")
+	used := make(map[string]bool)
+	app.ClearSkipped()
+	app.SetCacheStatus("")
+
+	var (
+		selections  []Selection
+		assignments []setter.Assignment
+	)
+
+	for _, output := range outputs {
+		outputApp := outputContext(app, output, pins[output.Name])
+
+		name := outputApp.Config.GetStringWithDefault("provider", "wallhaven")
+		provider, exists := GetProvider(name)
+		if !exists {
+			return "", fmt.Errorf("%w: %s", ErrUnknownProvider, name)
+		}
+
+		var path string
+		sel, err := tryCandidates(outputApp, func() (Selection, error) {
+			return selectDistinct(ctx, outputApp, provider, used)
+		}, func(sel Selection) error {
+			var err error
+			path, err = files.FetchWallpaper(ctx, app.Client, sel.File, sel.Provider, sel.FileSize())
+			return err
+		})
+		// The next output sees the query as already resolved
+		app.SetCacheStatus(outputApp.CacheStatus)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", output.Name, err)
+		}
+		if sel.IsEmpty() {
+			return "", nil
+		}
+		used[sel.Source] = true
+		prefetch(ctx, outputApp, sel)
+
+		selections = append(selections, sel)
+		assignments = append(assignments, setter.Assignment{Output: output.Name, Path: path})
+	}
+
+	if multi, ok := app.Setter.(setter.MultiSetter); ok {
+		if err := multi.SetOutputs(assignments); err != nil {
+			return "", err
+		}
+	} else {
+		slog.Warn("Setter does not support multiple outputs, using one wallpaper for all", "setter", app.Setter.Name())
+		if err := app.Setter.Set(assignments[0].Path); err != nil {
+			return "", err
+		}
+		selections = selections[:1]
+		assignments = assignments[:1]
+	}
+
+	applied := time.Now()
+	var lines []string
+	for i, sel := range selections {
+		if err := record(app, sel, assignments[i].Path, assignments[i].Output, applied); err != nil {
+			return "", err
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", assignments[i].Output, sel.Source))
+	}
+
+	// The first output is treated as the current wallpaper
+	current := currentFromSelection(selections[0], assignments[0].Path)
+	current.Output = assignments[0].Output
+	if err := writeCurrent(app, current); err != nil {
+		return "", err
+	}
+
+	evictAfterApply(app)
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// outputContext returns a copy of the app with the pinned config for an output
+// applied over the top of the main config. The screen size is set to the size
+// of the output so auto_fit works per output.
+func outputContext(app *appcontext.AppContext, output setter.Output, pin map[string]any) *appcontext.AppContext {
+	outputApp := *app
+	outputApp.Config = app.Config.Clone()
+
+	if output.Width > 0 && output.Height > 0 {
+		outputApp.Config.Override("screen", fmt.Sprintf("%dx%d", output.Width, output.Height))
+	}
+
+	if _, ok := pin["random"]; ok {
+		outputApp.Config.Override("hot", false)
+		outputApp.Config.Override("top", false)
+	}
+	outputApp.Config.Overrides(pin)
+
+	return &outputApp
+}
+
+func selectDistinct(ctx context.Context, app *appcontext.AppContext, provider Provider, used map[string]bool) (Selection, error) {
+	var sel Selection
+	var err error
+
+	for range distinctAttempts {
+		sel, err = provider.Select(ctx, app)
+		if err != nil || sel.IsEmpty() || !used[sel.Source] {
+			return sel, err
+		}
+	}
+
+	slog.Warn("Could not find a distinct wallpaper for output, reusing one", "wallpaper", sel.Source)
+	return sel, nil
+}
@@ -0,0 +1,272 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/davenicholson-xyz/wallmancer/appcontext"
+	"github.com/davenicholson-xyz/wallmancer/files"
+)
+
+// defaultPrefetch is how many wallpapers are downloaded ahead of time
+const defaultPrefetch = 3
+
+// PrefetchEnv is set for the process started to fill a prefetch queue after
+// a one-off command has exited. It holds a JSON prefetchJob.
+const PrefetchEnv = "WALLMANCER_PREFETCH"
+
+// prefetchLockAge is when a prefetch lock is assumed to be left over from a
+// process that died
+const prefetchLockAge = 15 * time.Minute
+
+// prefetchKeys are the config keys that affect which wallpapers are picked
+// ahead, passed on to the prefetch process as they may differ per output
+var prefetchKeys = []string{"screen", "auto_fit", "selection"}
+
+var queueMu sync.Mutex
+
+// queued is a prefetched wallpaper and where it was downloaded to
+type queued struct {
+	File      string    `json:"file"`
+	Wallpaper Wallpaper `json:"wallpaper"`
+}
+
+// prefetchJob is the selection to prefetch ahead of in another process
+type prefetchJob struct {
+	Provider string         `json:"provider"`
+	File     string         `json:"file"`
+	Results  string         `json:"results"`
+	Config   map[string]any `json:"config,omitempty"`
+}
+
+// prefetch picks the next wallpapers from the result set the selection came
+// from and downloads them in the background, so they can be applied without
+// waiting. The queue is kept at prefetch wallpapers deep, -1 turns it off.
+// The daemon prefetches in a goroutine on a snapshot of the app, other
+// commands start a detached process so they can exit straight away.
+func prefetch(ctx context.Context, app *appcontext.AppContext, sel Selection) {
+	depth := app.Config.GetIntWithDefault("prefetch", defaultPrefetch)
+	if depth <= 0 || sel.Results == "" || isOffline(app) {
+		return
+	}
+
+	queueMu.Lock()
+	full := len(readQueue(sel.Results)) >= depth
+	queueMu.Unlock()
+	if full {
+		return
+	}
+
+	if app.Config.GetBool("daemon") {
+		snapshot := app.Snapshot()
+		app.Go(func() {
+			fillQueue(ctx, snapshot, sel, depth)
+		})
+		return
+	}
+
+	if err := startPrefetch(app, sel); err != nil {
+		slog.Warn("Could not start prefetching", "error", err)
+	}
+}
+
+// startPrefetch runs wallmancer again with the same arguments to fill the
+// queue once this process has exited. It runs in its own session with its
+// output thrown away, so it outlives the terminal.
+func startPrefetch(app *appcontext.AppContext, sel Selection) error {
+	job := prefetchJob{Provider: sel.Provider, File: sel.File, Results: sel.Results, Config: make(map[string]any)}
+	for _, key := range prefetchKeys {
+		if value, ok := app.Config.Get(key); ok {
+			job.Config[key] = value
+		}
+	}
+
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	null, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	defer null.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), PrefetchEnv+"="+string(data))
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = null, null, null
+	detach(cmd)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return cmd.Process.Release()
+}
+
+// RunPrefetch fills the prefetch queue for the job passed in PrefetchEnv
+func RunPrefetch(ctx context.Context, app *appcontext.AppContext, spec string) error {
+	var job prefetchJob
+	if err := json.Unmarshal([]byte(spec), &job); err != nil {
+		return fmt.Errorf("Invalid prefetch job: %w", err)
+	}
+
+	app.Config.Overrides(job.Config)
+	fillQueue(ctx, app, Selection{Provider: job.Provider, File: job.File, Results: job.Results}, app.Config.GetIntWithDefault("prefetch", defaultPrefetch))
+	return nil
+}
+
+// fillQueue downloads wallpapers until the queue for the result set is depth
+// deep. Only one process fills the queue for a result set at a time.
+func fillQueue(ctx context.Context, app *appcontext.AppContext, sel Selection, depth int) {
+	unlock, ok := files.TryLock(sel.Results+files.LockExt, prefetchLockAge)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	queueMu.Lock()
+	queue := readQueue(sel.Results)
+	queueMu.Unlock()
+
+	need := depth - len(queue)
+	if need <= 0 {
+		return
+	}
+
+	for _, wp := range pickAhead(app, sel, queue, need) {
+		path, err := files.FetchWallpaper(ctx, app.Client, wp.Path, sel.Provider, wp.FileSize)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Warn("Could not prefetch wallpaper", "wallpaper", wp.Path, "error", err)
+			if isDeadLink(err) {
+				if err := markDead(Selection{File: wp.Path, Results: sel.Results}); err != nil {
+					slog.Warn("Could not record dead link", "error", err)
+				}
+			}
+			continue
+		}
+
+		queueMu.Lock()
+		err = writeQueue(sel.Results, append(readQueue(sel.Results), queued{File: path, Wallpaper: wp}))
+		queueMu.Unlock()
+		if err != nil {
+			slog.Warn("Could not update prefetch queue", "error", err)
+			return
+		}
+		slog.Info("Prefetched wallpaper", "wallpaper", wp.Path)
+	}
+}
+
+// pickAhead picks up to n wallpapers that are not the selection and not
+// already queued
+func pickAhead(app *appcontext.AppContext, sel Selection, queue []queued, n int) []Wallpaper {
+	results, err := candidateResults(app, sel.Results, func(wp Wallpaper) bool {
+		return wp.Path != sel.File && !slices.ContainsFunc(queue, func(q queued) bool {
+			return q.Wallpaper.Path == wp.Path
+		})
+	})
+	if err != nil {
+		return nil
+	}
+
+	var picks []Wallpaper
+	for len(picks) < n && len(results) > 0 {
+		wp, err := pickResult(app, sel.Results, results)
+		if err != nil {
+			break
+		}
+		picks = append(picks, *wp)
+		results = slices.DeleteFunc(results, func(r Wallpaper) bool {
+			return r.Path == wp.Path
+		})
+	}
+
+	return picks
+}
+
+// popQueue takes the first prefetched wallpaper that can still be used.
+// Wallpapers that can not are dropped from the queue.
+func popQueue(app *appcontext.AppContext, results string, keep func(wp Wallpaper) bool) *Wallpaper {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+
+	queue := readQueue(results)
+	if len(queue) == 0 {
+		return nil
+	}
+
+	dead := deadLinks(results)
+	for i, q := range queue {
+		wp := q.Wallpaper
+		usable := files.PathExists(q.File) &&
+			!dead[wp.Path] &&
+			!app.IsSkipped(wp.Path) &&
+			(keep == nil || keep(wp)) &&
+			!hasBannedTag(app, wp) &&
+			(app.Blacklist == nil || !app.Blacklist.IsBanned(wp.Path))
+
+		if usable {
+			if err := writeQueue(results, queue[i+1:]); err != nil {
+				slog.Warn("Could not update prefetch queue", "error", err)
+			}
+			return &wp
+		}
+	}
+
+	clearQueue(results)
+	return nil
+}
+
+func readQueue(results string) []queued {
+	lines, err := files.ReadLines(results + files.QueueExt)
+	if err != nil {
+		return nil
+	}
+
+	var queue []queued
+	for _, line := range lines {
+		var q queued
+		if err := json.Unmarshal([]byte(line), &q); err == nil {
+			queue = append(queue, q)
+		}
+	}
+
+	return queue
+}
+
+func writeQueue(results string, queue []queued) error {
+	if len(queue) == 0 {
+		clearQueue(results)
+		return nil
+	}
+
+	var lines []string
+	for _, q := range queue {
+		line, err := json.Marshal(q)
+		if err != nil {
+			return fmt.Errorf("Could not encode queue: %w", err)
+		}
+		lines = append(lines, string(line))
+	}
+
+	return files.WriteFileAtomic(results+files.QueueExt, []byte(strings.Join(lines, "\n")+"\n"))
+}
+
+func clearQueue(results string) {
+	os.Remove(results + files.QueueExt)
+}
@@ -0,0 +1,102 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davenicholson-xyz/go-cachetools/cachetools"
+	"github.com/davenicholson-xyz/wallmancer/appcontext"
+	"github.com/davenicholson-xyz/wallmancer/blacklist"
+	"github.com/davenicholson-xyz/wallmancer/config"
+	"github.com/davenicholson-xyz/wallmancer/download"
+)
+
+// TestPrefetchDaemon fills the queue in the background while the app is
+// changed the way the daemon does between rotations. Run with -race.
+func TestPrefetchDaemon(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(home, ".cache"))
+
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(img.Bytes())
+	}))
+	defer server.Close()
+
+	app := newTestApp(t, home)
+	app.Config.Override("daemon", true)
+
+	var lines []string
+	for i := range 6 {
+		line, _ := json.Marshal(Wallpaper{ID: fmt.Sprintf("test0%d", i), Path: fmt.Sprintf("%s/wallhaven-test0%d.png", server.URL, i)})
+		lines = append(lines, string(line))
+	}
+	results := app.CacheTools.Join(filepath.Join("wallhaven", "query"))
+	if err := os.MkdirAll(filepath.Dir(results), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(results, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	first := fmt.Sprintf("%s/wallhaven-test00.png", server.URL)
+	prefetch(context.Background(), app, Selection{Provider: "wallhaven", File: first, Results: results})
+
+	for i := range 50 {
+		app.ClearSkipped()
+		app.Skip(first)
+		app.Config.Override("selection", "random")
+		app.Config.Overrides(map[string]any{"screen": "1920x1080"})
+		if err := app.Blacklist.BanTag(fmt.Sprintf("tag%d", i)); err != nil {
+			t.Fatal(err)
+		}
+		app.Blacklist.IsBanned(first)
+		time.Sleep(time.Millisecond)
+	}
+	app.Wait()
+
+	if queue := readQueue(results); len(queue) != defaultPrefetch {
+		t.Errorf("prefetched %d wallpapers, want %d", len(queue), defaultPrefetch)
+	}
+}
+
+func newTestApp(t *testing.T, home string) *appcontext.AppContext {
+	t.Helper()
+
+	cfg, err := config.New(filepath.Join(home, "config.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct, err := cachetools.New("wallmancer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := download.NewClient(download.ClientOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := appcontext.NewAppContext()
+	app.AddConfig(cfg)
+	app.AddCacheTools(ct)
+	app.AddClient(client)
+	app.AddBlacklist(blacklist.New(filepath.Join(home, "data")))
+	return app
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package providers
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// detach starts cmd in its own session so closing the terminal does not stop
+// it
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
@@ -1,8 +1,211 @@
 package providers
 
-import "github.com/davenicholson-xyz/wallmancer/appcontext"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/davenicholson-xyz/wallmancer/appcontext"
+	"github.com/davenicholson-xyz/wallmancer/files"
+	"github.com/davenicholson-xyz/wallmancer/history"
+	"github.com/davenicholson-xyz/wallmancer/setter"
+)
+
+var (
+	ErrNotCached = errors.New("Wallpaper is no longer in the cache")
+	ErrNoCurrent = errors.New("No current wallpaper")
+
+	ErrNoCandidates = errors.New("No wallpapers left that are not blacklisted")
+	ErrNoWallpapers = errors.New("No wallpapers found")
+)
 
 type Provider interface {
 	Name() string
-	ParseArgs(app *appcontext.AppContext) (string, error)
+	Select(ctx context.Context, app *appcontext.AppContext) (Selection, error)
+}
+
+// Selection is a wallpaper chosen by a provider that has not been applied yet
+type Selection struct {
+	Provider string
+	Source   string // where the wallpaper came from, eg. the image URL
+	File     string // the URL to download or the local file to set
+	Query    string
+
+	// Results is the cached result set the wallpaper was picked from, if any
+	Results string
+
+	// Wallpaper holds the metadata for providers that have it
+	Wallpaper *Wallpaper
+}
+
+func (s Selection) IsEmpty() bool {
+	return s.File == ""
+}
+
+// FileSize is the expected size of the image in bytes, 0 when not known
+func (s Selection) FileSize() int64 {
+	if s.Wallpaper == nil {
+		return 0
+	}
+	return s.Wallpaper.FileSize
+}
+
+// Apply selects a wallpaper from the provider and sets it on every output.
+// When a wallpaper can not be downloaded or set another one is tried.
+func Apply(ctx context.Context, app *appcontext.AppContext, p Provider) (string, error) {
+	var output string
+	app.ClearSkipped()
+	app.SetCacheStatus("")
+
+	sel, err := tryCandidates(app, func() (Selection, error) {
+		return p.Select(ctx, app)
+	}, func(sel Selection) error {
+		var err error
+		output, err = files.ApplyWallpaper(ctx, app.Client, sel.File, sel.Provider, sel.FileSize(), app.Setter)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("%w", err)
+	}
+
+	if sel.IsEmpty() {
+		return "", nil
+	}
+
+	if err := record(app, sel, output, "", time.Now()); err != nil {
+		return "", err
+	}
+
+	evictAfterApply(app)
+	prefetch(ctx, app, sel)
+
+	return sel.Source, nil
+}
+
+// selectCandidate picks a candidate that is not on the blacklist. With the
+// shuffle selection mode candidates are not repeated until all of them have
+// been used, the picks are tracked in the bag file.
+func selectCandidate(app *appcontext.AppContext, candidates []string, bag string) (string, error) {
+	if bag == "" || app.Config.GetStringWithDefault("selection", "random") != "shuffle" {
+		return pickCandidate(app, candidates)
+	}
+
+	shuffle := files.NewShuffleBag(bag)
+
+	selected, err := pickCandidate(app, shuffle.Unseen(candidates))
+	if errors.Is(err, ErrNoCandidates) {
+		slog.Info("All candidates used, resetting shuffle bag")
+		if err := shuffle.Reset(); err != nil {
+			return "", err
+		}
+		selected, err = pickCandidate(app, candidates)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err := shuffle.Mark(selected); err != nil {
+		return "", err
+	}
+
+	return selected, nil
+}
+
+// pickCandidate picks a random candidate that is not on the blacklist and has
+// not already failed this run. Candidates are checked one at a time so only
+// the ones picked are hashed.
+func pickCandidate(app *appcontext.AppContext, candidates []string) (string, error) {
+	remaining := slices.Clone(candidates)
+
+	for len(remaining) > 0 {
+		i := rand.Intn(len(remaining))
+		candidate := remaining[i]
+
+		if !app.IsSkipped(candidate) && (app.Blacklist == nil || !app.Blacklist.IsBanned(candidate)) {
+			return candidate, nil
+		}
+
+		remaining = slices.Delete(remaining, i, i+1)
+	}
+
+	return "", ErrNoCandidates
+}
+
+// record writes the current file for the provider and adds the wallpaper to
+// the history. Wallpapers set on several outputs together share applied.
+func record(app *appcontext.AppContext, sel Selection, path string, outputName string, applied time.Time) error {
+	if err := writeCurrent(app, currentFromSelection(sel, path)); err != nil {
+		return err
+	}
+
+	if app.History != nil {
+		entry := history.Entry{
+			Time:     applied,
+			Provider: sel.Provider,
+			Source:   sel.Source,
+			Path:     path,
+			Query:    sel.Query,
+			Output:   outputName,
+		}
+		if err := app.History.Append(entry); err != nil {
+			return fmt.Errorf("%w", err)
+		}
+	}
+
+	return nil
+}
+
+// ApplyHistory re-applies wallpapers from the history using the copies
+// already in the cache. Nothing is downloaded. Entries from a multi monitor
+// apply are set on the outputs they were shown on.
+func ApplyHistory(ctx context.Context, app *appcontext.AppContext, entries []history.Entry) (string, error) {
+	var (
+		assignments []setter.Assignment
+		lines       []string
+	)
+	for _, entry := range entries {
+		if !files.PathExists(entry.Path) {
+			return "", fmt.Errorf("%w: %s", ErrNotCached, entry.Path)
+		}
+
+		path, err := files.FetchWallpaper(ctx, app.Client, entry.Path, entry.Provider, 0)
+		if err != nil {
+			return "", fmt.Errorf("%w", err)
+		}
+		assignments = append(assignments, setter.Assignment{Output: entry.Output, Path: path})
+		lines = append(lines, fmt.Sprintf("%s: %s", entry.Output, entry.Source))
+	}
+
+	if multi, ok := app.Setter.(setter.MultiSetter); ok && len(entries) > 1 {
+		if err := multi.SetOutputs(assignments); err != nil {
+			return "", err
+		}
+	} else {
+		if len(entries) > 1 {
+			slog.Warn("Setter does not support multiple outputs, using one wallpaper for all", "setter", app.Setter.Name())
+		}
+		if err := app.Setter.Set(assignments[0].Path); err != nil {
+			return "", err
+		}
+		entries = entries[:1]
+		lines = []string{entries[0].Source}
+	}
+
+	current := CurrentWallpaper{
+		Provider: entries[0].Provider,
+		Source:   entries[0].Source,
+		Path:     assignments[0].Path,
+		Query:    entries[0].Query,
+		Output:   entries[0].Output,
+	}
+	if err := writeCurrent(app, current); err != nil {
+		return "", err
+	}
+
+	return strings.Join(lines, "\n"), nil
 }
@@ -1,6 +1,13 @@
 package providers
 
-import "sync"
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	ErrUnknownProvider = errors.New("Unknown provider")
+)
 
 var (
 	providers     = make(map[string]Provider)
@@ -22,4 +29,6 @@ func GetProvider(name string) (Provider, bool) {
 
 func init() {
 	RegisterProvider(&WallhavenProvider{})
+	RegisterProvider(&LocalProvider{})
+	RegisterProvider(&FavouritesProvider{})
 }
@@ -1,11 +1,15 @@
 package providers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 
 	"github.com/davenicholson-xyz/wallmancer/appcontext"
 	"github.com/davenicholson-xyz/wallmancer/download"
@@ -14,9 +18,43 @@ import (
 
 type WallhavenProvider struct{}
 
+// Wallpaper is a single wallhaven result. Results are cached with all of
+// their metadata. Tags and Uploader are only returned by the wallpaper info
+// endpoint, not by search.
 type Wallpaper struct {
-	ID   string `json:"id"`
-	Path string `json:"path"`
+	ID         string             `json:"id"`
+	URL        string             `json:"url,omitempty"`
+	ShortURL   string             `json:"short_url,omitempty"`
+	Views      int                `json:"views,omitempty"`
+	Favorites  int                `json:"favorites,omitempty"`
+	Source     string             `json:"source,omitempty"`
+	Purity     string             `json:"purity,omitempty"`
+	Category   string             `json:"category,omitempty"`
+	DimensionX int                `json:"dimension_x,omitempty"`
+	DimensionY int                `json:"dimension_y,omitempty"`
+	Resolution string             `json:"resolution,omitempty"`
+	Ratio      string             `json:"ratio,omitempty"`
+	FileSize   int64              `json:"file_size,omitempty"`
+	FileType   string             `json:"file_type,omitempty"`
+	CreatedAt  string             `json:"created_at,omitempty"`
+	Colors     []string           `json:"colors,omitempty"`
+	Path       string             `json:"path"`
+	Thumbs     map[string]string  `json:"thumbs,omitempty"`
+	Tags       []WallhavenTag     `json:"tags,omitempty"`
+	Uploader   *WallhavenUploader `json:"uploader,omitempty"`
+}
+
+type WallhavenTag struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Alias    string `json:"alias,omitempty"`
+	Category string `json:"category,omitempty"`
+	Purity   string `json:"purity,omitempty"`
+}
+
+type WallhavenUploader struct {
+	Username string `json:"username"`
+	Group    string `json:"group,omitempty"`
 }
 
 type WallhavenData struct {
@@ -33,29 +71,50 @@ func (w *WallhavenProvider) Name() string {
 	return "wallhaven"
 }
 
-func (w *WallhavenProvider) ParseArgs(app *appcontext.AppContext) (string, error) {
+// apiURL is the base URL of the wallhaven API, api_url in the config
+func apiURL(app *appcontext.AppContext) string {
+	return strings.TrimSuffix(app.Config.GetStringWithDefault("api_url", "https://wallhaven.cc/api/v1"), "/")
+}
+
+func (w *WallhavenProvider) Select(ctx context.Context, app *appcontext.AppContext) (Selection, error) {
 
 	if app.Config.GetString("random") != "" || app.Config.GetBool("top") || app.Config.GetBool("hot") {
-		wp, err := w.fetchRandom(app)
+		wp, err := w.fetchRandom(ctx, app)
 		if err != nil {
-			return "", err
+			return Selection{}, err
 		}
 		return wp, nil
 	}
 
-	return "", nil
+	return Selection{}, nil
 }
 
-func (w *WallhavenProvider) fetchRandom(app *appcontext.AppContext) (string, error) {
-	url := download.NewURL("https://wallhaven.cc/api/v1/search")
+// countCacheStatus adds how the query was resolved to the providers counters
+// shown by cache stats
+func (w *WallhavenProvider) countCacheStatus(app *appcontext.AppContext) {
+	if app.CacheStatus == "" {
+		return
+	}
+
+	counters := app.CacheTools.Join(filepath.Join(w.Name(), files.CountersFile))
+	if err := files.IncrementCacheCounter(counters, app.CacheStatus); err != nil {
+		slog.Warn("Could not update cache counters", "error", err)
+	}
+}
+
+func (w *WallhavenProvider) fetchRandom(ctx context.Context, app *appcontext.AppContext) (Selection, error) {
+	// The query is resolved once per apply, picking another candidate after
+	// a failure or for another output reuses it and is not counted again
+	if app.CacheStatus == "" {
+		defer w.countCacheStatus(app)
+	}
+
+	url := download.NewURL(apiURL(app) + "/search")
 	app.AddURLBuilder(url)
 
 	lm := download.NewLinkManager()
 	app.AddLinkManager(lm)
 
-	var outfile string
-	var selected string
-
 	seed := app.Config.GetStringWithDefault("seed", download.GenerateSeed(6))
 	app.URLBuilder.AddString("seed", seed)
 
@@ -73,63 +132,70 @@ func (w *WallhavenProvider) fetchRandom(app *appcontext.AppContext) (string, err
 	if random != "" {
 		app.URLBuilder.SetString("sorting", "random")
 		app.URLBuilder.AddString("q", random)
-		outfile = filepath.Join("wallhaven", "random")
 	}
 
 	if app.Config.GetBool("hot") {
 		url.SetString("sorting", "hot")
-		outfile = filepath.Join("wallhaven", "hot")
 	}
 
 	if app.Config.GetBool("top") {
 		app.URLBuilder.SetString("sorting", "toplist")
-		outfile = filepath.Join("wallhaven", "top")
 	}
 
-	selected, err := checkCacheForQuery(app, outfile)
-	if err != nil {
-		return "", fmt.Errorf("%w", err)
+	if err := applyFilters(app); err != nil {
+		return Selection{}, err
 	}
 
-	if selected != "" {
-		output, err := files.ApplyWallpaper(selected, w.Name())
-		if err != nil {
-			return "", fmt.Errorf("%w", err)
-		}
-		current_string := fmt.Sprintf("%s\n%s", selected, output)
-		err = app.CacheTools.WriteStringToFile("wallhaven/current", current_string)
-		if err != nil {
-			return "", fmt.Errorf("%w", err)
-		}
-		return selected, nil
+	outfile := files.QueryCachePath(w.Name(), cacheQuery(app))
+
+	if isOffline(app) {
+		return w.selectOffline(app, outfile)
 	}
 
-	selected, err = fetchQuery(app, outfile)
+	selected, err := checkCacheForQuery(app, outfile)
 	if err != nil {
-		return "", fmt.Errorf("%w", err)
+		return Selection{}, fmt.Errorf("%w", err)
 	}
 
-	if selected != "" {
-		output, err := files.ApplyWallpaper(selected, w.Name())
-		if err != nil {
-			return "", fmt.Errorf("%w", err)
-		}
-		current_string := fmt.Sprintf("%s\n%s", selected, output)
-		err = app.CacheTools.WriteStringToFile("wallhaven/current", current_string)
+	if selected != nil {
+		return w.selection(app, selected, app.CacheTools.Join(outfile)), nil
+	}
+
+	// Work offline only when wallhaven can not be reached. A request it
+	// rejects, eg. for a bad apikey, fails.
+	selected, err = fetchQuery(ctx, app, outfile)
+	if errors.Is(err, download.ErrNetwork) && ctx.Err() == nil {
+		slog.Warn("Could not reach wallhaven, working offline", "error", err)
+		return w.selectOffline(app, outfile)
+	}
+	if err != nil {
+		selected, err = checkStaleCache(app, outfile, err)
 		if err != nil {
-			return "", fmt.Errorf("%w", err)
+			return Selection{}, fmt.Errorf("%w", err)
 		}
-		return selected, nil
 	}
 
-	return "", nil
+	if selected != nil {
+		return w.selection(app, selected, app.CacheTools.Join(outfile)), nil
+	}
+
+	return Selection{}, nil
 
 }
 
-func processPage(app *appcontext.AppContext) (int, int, error) {
-	request := app.URLBuilder.Build()
+func (w *WallhavenProvider) selection(app *appcontext.AppContext, selected *Wallpaper, results string) Selection {
+	return Selection{
+		Provider:  w.Name(),
+		Source:    selected.Path,
+		File:      selected.Path,
+		Query:     queryLabel(app),
+		Results:   results,
+		Wallpaper: selected,
+	}
+}
 
-	resp, err := download.FetchJson(request)
+func processPage(ctx context.Context, app *appcontext.AppContext, request string, page int) (int, int, error) {
+	resp, err := app.Client.FetchJson(ctx, request)
 	if err != nil {
 		return 0, 0, fmt.Errorf("Could not fetch page: %w", err)
 	}
@@ -139,91 +205,259 @@ func processPage(app *appcontext.AppContext) (int, int, error) {
 		return 0, 0, fmt.Errorf("Could not process JSON data: %w", err)
 	}
 
+	// Each result is cached as a line of JSON so the metadata is kept
 	var links []string
-	for _, link := range wd.Wallpapers {
-		links = append(links, link.Path)
+	for _, wp := range wd.Wallpapers {
+		line, err := json.Marshal(wp)
+		if err != nil {
+			return 0, 0, fmt.Errorf("Could not encode wallpaper: %w", err)
+		}
+		links = append(links, string(line))
 	}
 
-	app.LinkManager.AddLinks(links)
+	app.LinkManager.AddPage(page, links)
 
 	return wd.Meta.Total, wd.Meta.LastPage, nil
 }
 
-func checkCacheForQuery(app *appcontext.AppContext, outfile string) (string, error) {
-	slog.Info("Checking cache for query")
-
-	if outfile == "wallhaven/random" {
-		last_query, err := app.CacheTools.ReadLineFromFile("wallhaven/last_query", 1)
-		if err != nil {
-			return "", nil
-		}
+// fetchPages fetches the pages from first to last using a bounded pool of
+// workers. The first failing page cancels any pages not yet started.
+func fetchPages(ctx context.Context, app *appcontext.AppContext, first int, last int) error {
+	workers := min(max(app.Config.GetIntWithDefault("concurrency", 3), 1), last-first+1)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pages := make(chan int)
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for page := range pages {
+				if ctx.Err() != nil {
+					return
+				}
 
-		cleanUrl := app.URLBuilder.Without("apikey").Without("seed")
-		query_url := cleanUrl.Build()
+				url := app.URLBuilder.Clone()
+				url.SetInt("page", page)
+				request := url.Build()
 
-		if last_query == query_url {
-			if files.IsFileFresh(app.CacheTools.Join(outfile), app.Config.GetIntWithDefault("expiry", 600)) {
-				slog.Info("Using cached results")
-				selected, err := files.GetRandomLine(app.CacheTools.Join(outfile))
-				if err != nil {
-					return "", fmt.Errorf("%w", err)
+				if _, _, err := processPage(ctx, app, request, page); err != nil {
+					errOnce.Do(func() {
+						firstErr = fmt.Errorf("Unable to process page: %v -- %w", request, err)
+						cancel()
+					})
+					return
 				}
-				return selected, nil
 			}
-		} else {
-			return "", nil
+		}()
+	}
+
+dispatch:
+	for page := first; page <= last; page++ {
+		select {
+		case pages <- page:
+		case <-ctx.Done():
+			break dispatch
 		}
 	}
+	close(pages)
+
+	wg.Wait()
+
+	return firstErr
+}
+
+func checkCacheForQuery(app *appcontext.AppContext, outfile string) (*Wallpaper, error) {
+	slog.Info("Checking cache for query")
+
+	cached_query, err := app.CacheTools.ReadLineFromFile(outfile+files.QueryExt, 1)
+	if err != nil {
+		return nil, nil
+	}
+
+	if cached_query != cacheQuery(app) {
+		return nil, nil
+	}
 
 	if files.IsFileFresh(app.CacheTools.Join(outfile), app.Config.GetIntWithDefault("expiry", 600)) {
-		selected, err := files.GetRandomLine(app.CacheTools.Join(outfile))
+		slog.Info("Using cached results")
+		app.SetCacheStatus("hit")
+		selected, err := selectFromResults(app, app.CacheTools.Join(outfile))
 		if err != nil {
-			return "", fmt.Errorf("%w", err)
+			return nil, fmt.Errorf("%w", err)
 		}
 		return selected, nil
 	}
 
-	return "", nil
+	return nil, nil
 }
 
-func fetchQuery(app *appcontext.AppContext, outfile string) (string, error) {
-	slog.Info("Using new query results")
+// ReadResults reads a cached result set. Older caches that only hold the
+// image URL on each line are still understood.
+func ReadResults(filename string) ([]Wallpaper, error) {
+	lines, err := files.ReadLines(filename)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	var results []Wallpaper
+	for _, line := range lines {
+		var wp Wallpaper
+		if err := json.Unmarshal([]byte(line), &wp); err != nil {
+			wp = Wallpaper{Path: strings.TrimSpace(line)}
+		}
+		if wp.Path != "" {
+			results = append(results, wp)
+		}
+	}
+
+	return results, nil
+}
+
+// selectFromResults picks a wallpaper from a cached result set, leaving out
+// dead links and any that do not fit the screen when auto_fit is on
+func selectFromResults(app *appcontext.AppContext, filename string) (*Wallpaper, error) {
+	return selectFromResultsWhere(app, filename, nil)
+}
+
+// selectFromResultsWhere is selectFromResults only considering the results
+// keep returns true for. Wallpapers already prefetched for the result set are
+// used first.
+func selectFromResultsWhere(app *appcontext.AppContext, filename string, keep func(wp Wallpaper) bool) (*Wallpaper, error) {
+	if wp := popQueue(app, filename, keep); wp != nil {
+		slog.Info("Using prefetched wallpaper", "wallpaper", wp.Path)
+		return wp, nil
+	}
+
+	results, err := candidateResults(app, filename, keep)
+	if err != nil {
+		return nil, err
+	}
+
+	return pickResult(app, filename, results)
+}
+
+// candidateResults reads a result set leaving out dead links, any that keep
+// returns false for and any that do not fit the screen when auto_fit is on
+func candidateResults(app *appcontext.AppContext, filename string, keep func(wp Wallpaper) bool) ([]Wallpaper, error) {
+	results, err := ReadResults(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	dead := deadLinks(filename)
+	results = slices.DeleteFunc(results, func(wp Wallpaper) bool {
+		return dead[wp.Path] || (keep != nil && !keep(wp)) || hasBannedTag(app, wp)
+	})
+	if len(results) == 0 {
+		return nil, ErrNoWallpapers
+	}
+
+	if app.Config.GetBool("auto_fit") {
+		results, err = fitScreen(app, results)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+// pickResult picks one of the results, tracking picks in the result set's
+// shuffle bag
+func pickResult(app *appcontext.AppContext, filename string, results []Wallpaper) (*Wallpaper, error) {
+	byPath := make(map[string]*Wallpaper, len(results))
+	var candidates []string
+	for i := range results {
+		byPath[results[i].Path] = &results[i]
+		candidates = append(candidates, results[i].Path)
+	}
+
+	selected, err := selectCandidate(app, candidates, filename+files.SeenExt)
+	if err != nil {
+		return nil, err
+	}
+
+	return byPath[selected], nil
+}
+
+// checkStaleCache falls back to an expired result set for the query when
+// fetching new results has failed
+func checkStaleCache(app *appcontext.AppContext, outfile string, fetchErr error) (*Wallpaper, error) {
+	if errors.Is(fetchErr, ErrNoWallpapers) || isRejected(fetchErr) || !files.PathExists(app.CacheTools.Join(outfile)) {
+		return nil, fetchErr
+	}
+
+	slog.Warn("Fetching results failed, using stale cached results", "error", fetchErr)
+	app.SetCacheStatus("stale")
+	return selectFromResults(app, app.CacheTools.Join(outfile))
+}
+
+// isRejected reports whether wallhaven answered with an error, eg. 401 for a
+// bad apikey, rather than not answering at all
+func isRejected(err error) bool {
+	return errors.Is(err, download.ErrHTTPStatus) && !errors.Is(err, download.ErrNetwork)
+}
+
+// cacheQuery is the search URL without the values that change between runs.
+// Any change to the query or filters will give a different cacheQuery.
+func cacheQuery(app *appcontext.AppContext) string {
+	cleanUrl := app.URLBuilder.Without("apikey").Without("seed")
+	return cleanUrl.Build()
+}
 
-	if outfile == "wallhaven/random" {
-		cleanUrl := app.URLBuilder.Without("apikey").Without("seed")
-		query_url := cleanUrl.Build()
-		slog.Info(query_url)
-		app.CacheTools.WriteStringToFile("wallhaven/last_query", query_url)
+// queryLabel is a short description of the search for the history
+func queryLabel(app *appcontext.AppContext) string {
+	label := app.URLBuilder.GetString("sorting")
+	if q := app.URLBuilder.GetString("q"); q != "" {
+		label = fmt.Sprintf("%s %s", label, q)
 	}
+	return label
+}
+
+func fetchQuery(ctx context.Context, app *appcontext.AppContext, outfile string) (*Wallpaper, error) {
+	slog.Info("Using new query results")
+	app.SetCacheStatus("miss")
 
-	_, last, err := processPage(app)
+	query_url := cacheQuery(app)
+	slog.Info(query_url)
+
+	_, last, err := processPage(ctx, app, app.URLBuilder.Build(), 1)
 	if err != nil {
-		return "", fmt.Errorf("Unable to process page: %v -- %w", app.URLBuilder.Build(), err)
+		return nil, fmt.Errorf("Unable to process page: %v -- %w", app.URLBuilder.Build(), err)
 	}
 
 	if app.LinkManager.Count() == 0 {
-		files.WriteStringToCache(filepath.Join("wallhaven", "last_query"), "")
-		return "", fmt.Errorf("No wallpapers found")
+		files.RemoveQuery(app.CacheTools.Join(outfile))
+		return nil, ErrNoWallpapers
 	}
 
-	//TODO: Dont forget to make this concurrent
 	if last > 1 {
 		last_page := min(last, app.Config.GetIntWithDefault("max_pages", 5))
-		for page := 2; page <= last_page; page++ {
-			app.URLBuilder.SetInt("page", page)
-			_, _, err = processPage(app)
-			if err != nil {
-				return "", fmt.Errorf("Unable to process page: %v -- %w", app.URLBuilder.Build(), err)
-			}
+		if err := fetchPages(ctx, app, 2, last_page); err != nil {
+			return nil, err
 		}
 	}
 
+	// A new result set starts a new shuffle bag and prefetch queue
+	files.NewShuffleBag(app.CacheTools.Join(outfile + files.SeenExt)).Reset()
+	clearQueue(app.CacheTools.Join(outfile))
+
 	all_links := strings.Join(app.LinkManager.GetLinks(), "\n")
 	app.CacheTools.WriteStringToFile(outfile, all_links)
+	app.CacheTools.WriteStringToFile(outfile+files.QueryExt, query_url)
 
-	selected, err := files.GetRandomLine(app.CacheTools.Join(outfile))
+	selected, err := selectFromResults(app, app.CacheTools.Join(outfile))
 	if err != nil {
-		return "", fmt.Errorf("%w", err)
+		return nil, fmt.Errorf("%w", err)
 	}
 	return selected, nil
 }
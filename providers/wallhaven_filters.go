@@ -0,0 +1,179 @@
+package providers
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/davenicholson-xyz/wallmancer/appcontext"
+)
+
+var (
+	ErrInvalidFilter = errors.New("Invalid search filter")
+)
+
+var (
+	resolutionPattern = regexp.MustCompile(`^\d+x\d+$`)
+	ratioPattern      = regexp.MustCompile(`^\d+(\.\d+)?x\d+(\.\d+)?$`)
+	colorPattern      = regexp.MustCompile(`^[0-9a-fA-F]{6}$`)
+
+	wallhavenCategories = []string{"general", "anime", "people"}
+	wallhavenTopRanges  = []string{"1d", "3d", "1w", "1M", "3M", "6M", "1y"}
+	wallhavenOrders     = []string{"desc", "asc"}
+)
+
+// applyFilters validates the search filter config keys and adds them to the
+// URLBuilder. Nothing is added if any of the filters are invalid. categories
+// and colors are read as written, YAML would read 010 as an octal number.
+func applyFilters(app *appcontext.AppContext) error {
+	categories, err := parseCategories(app.Config.GetText("categories"))
+	if err != nil {
+		return err
+	}
+
+	atleast := app.Config.GetString("atleast")
+	if atleast != "" && !resolutionPattern.MatchString(atleast) {
+		return invalidFilter("atleast", atleast)
+	}
+
+	resolutions, err := parseList("resolutions", app.Config.GetStringSlice("resolutions"), func(v string) bool {
+		return resolutionPattern.MatchString(v)
+	})
+	if err != nil {
+		return err
+	}
+
+	ratios, err := parseList("ratios", app.Config.GetStringSlice("ratios"), func(v string) bool {
+		return v == "landscape" || v == "portrait" || ratioPattern.MatchString(v)
+	})
+	if err != nil {
+		return err
+	}
+
+	colors := strings.TrimPrefix(app.Config.GetText("colors"), "#")
+	if colors != "" && !colorPattern.MatchString(colors) {
+		return invalidFilter("colors", colors)
+	}
+
+	topRange := app.Config.GetString("topRange")
+	if topRange != "" && !slices.Contains(wallhavenTopRanges, topRange) {
+		return invalidFilter("topRange", topRange)
+	}
+
+	order := app.Config.GetString("order")
+	if order != "" && !slices.Contains(wallhavenOrders, order) {
+		return invalidFilter("order", order)
+	}
+
+	aiArtFilter := ""
+	if val := app.Config.GetString("ai_art_filter"); val != "" {
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return invalidFilter("ai_art_filter", val)
+		}
+		aiArtFilter = "0"
+		if b {
+			aiArtFilter = "1"
+		}
+	}
+
+	app.URLBuilder.SetString("categories", categories)
+	app.URLBuilder.SetString("atleast", atleast)
+	app.URLBuilder.SetString("resolutions", resolutions)
+	app.URLBuilder.SetString("ratios", ratios)
+	app.URLBuilder.SetString("colors", strings.ToLower(colors))
+	app.URLBuilder.SetString("order", order)
+	app.URLBuilder.SetString("ai_art_filter", aiArtFilter)
+
+	if app.URLBuilder.GetString("sorting") == "toplist" {
+		app.URLBuilder.SetString("topRange", topRange)
+	}
+
+	excludeBannedTags(app)
+
+	return nil
+}
+
+// excludeBannedTags adds the banned tags to the search as -tag. wallhaven only
+// understands single words this way, longer tags are left to hasBannedTag.
+func excludeBannedTags(app *appcontext.AppContext) {
+	if app.Blacklist == nil {
+		return
+	}
+
+	query := app.URLBuilder.GetString("q")
+	for _, tag := range app.Blacklist.Tags() {
+		if !strings.ContainsAny(tag, " \t") {
+			query += " -" + tag
+		}
+	}
+
+	if query = strings.TrimSpace(query); query != "" {
+		app.URLBuilder.SetString("q", query)
+	}
+}
+
+// hasBannedTag checks the tags of a result against the blacklist. Search
+// results do not include tags so the wallpaper info is used when it has
+// already been fetched.
+func hasBannedTag(app *appcontext.AppContext, wp Wallpaper) bool {
+	if app.Blacklist == nil {
+		return false
+	}
+
+	tags := wp.Tags
+	if len(tags) == 0 {
+		tags = cachedTags(app, wp.ID)
+	}
+
+	names := make([]string, len(tags))
+	for i, tag := range tags {
+		names[i] = tag.Name
+	}
+	return app.Blacklist.HasBannedTag(names)
+}
+
+// parseCategories accepts either the wallhaven bit string (eg. 110) or a comma
+// separated list of category names (eg. general,anime)
+func parseCategories(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	if len(value) == 3 && strings.Trim(value, "01") == "" {
+		return value, nil
+	}
+
+	bits := []byte("000")
+	for _, name := range strings.Split(value, ",") {
+		idx := slices.Index(wallhavenCategories, strings.ToLower(strings.TrimSpace(name)))
+		if idx < 0 {
+			return "", invalidFilter("categories", value)
+		}
+		bits[idx] = '1'
+	}
+
+	return string(bits), nil
+}
+
+// parseList checks every entry of a list filter, given as a YAML list or a
+// comma separated string, and joins them for the URL
+func parseList(key string, values []string, valid func(string) bool) (string, error) {
+	var entries []string
+	for _, entry := range values {
+		entry = strings.TrimSpace(entry)
+		if !valid(entry) {
+			return "", invalidFilter(key, entry)
+		}
+		entries = append(entries, entry)
+	}
+
+	return strings.Join(entries, ","), nil
+}
+
+func invalidFilter(key string, value string) error {
+	return fmt.Errorf("%w: %s=%q", ErrInvalidFilter, key, value)
+}
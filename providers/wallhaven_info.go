@@ -0,0 +1,98 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/davenicholson-xyz/wallmancer/appcontext"
+	"github.com/davenicholson-xyz/wallmancer/blacklist"
+	"github.com/davenicholson-xyz/wallmancer/download"
+	"github.com/davenicholson-xyz/wallmancer/files"
+)
+
+var (
+	ErrNoWallpaperID = errors.New("Wallpaper does not have a wallhaven ID")
+)
+
+type wallhavenInfo struct {
+	Data Wallpaper `json:"data"`
+}
+
+// WallhavenID returns the wallhaven ID of the current wallpaper, from its
+// metadata or failing that from the image URL
+func WallhavenID(current CurrentWallpaper) string {
+	if current.Wallpaper != nil && current.Wallpaper.ID != "" {
+		return current.Wallpaper.ID
+	}
+	return blacklist.IDFromSource(current.Source)
+}
+
+// WallhavenInfo fetches the full details of a wallpaper. Responses are cached
+// for info_expiry seconds (a day by default).
+func WallhavenInfo(ctx context.Context, app *appcontext.AppContext, id string) (*Wallpaper, error) {
+	if id == "" {
+		return nil, ErrNoWallpaperID
+	}
+
+	cachefile := infoCachePath(id)
+	expiry := app.Config.GetIntWithDefault("info_expiry", 86400)
+
+	var data []byte
+	if files.IsFileFresh(app.CacheTools.Join(cachefile), expiry) || isOffline(app) {
+		if content, err := files.ReadLine(app.CacheTools.Join(cachefile)); err == nil {
+			data = []byte(content)
+		}
+	}
+
+	if data == nil && isOffline(app) {
+		return nil, fmt.Errorf("%w: wallpaper info for %s", ErrOffline, id)
+	}
+
+	if data == nil {
+		url := download.NewURL(apiURL(app) + "/w/" + id)
+		url.AddString("apikey", app.Config.GetString("apikey"))
+
+		resp, err := app.Client.FetchJson(ctx, url.Build())
+		if err != nil {
+			return nil, fmt.Errorf("Could not fetch wallpaper info: %w", err)
+		}
+		data = resp
+
+		if err := app.CacheTools.WriteStringToFile(cachefile, string(data)); err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+	}
+
+	var info wallhavenInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("Could not process JSON data: %w", err)
+	}
+
+	return &info.Data, nil
+}
+
+func infoCachePath(id string) string {
+	return filepath.Join("wallhaven", "info", id+".json")
+}
+
+// cachedTags returns the tags of a wallpaper from its cached info, whatever
+// its age. Nothing is fetched.
+func cachedTags(app *appcontext.AppContext, id string) []WallhavenTag {
+	if id == "" {
+		return nil
+	}
+
+	content, err := files.ReadLine(app.CacheTools.Join(infoCachePath(id)))
+	if err != nil {
+		return nil
+	}
+
+	var info wallhavenInfo
+	if err := json.Unmarshal([]byte(content), &info); err != nil {
+		return nil
+	}
+	return info.Data.Tags
+}
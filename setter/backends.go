@@ -0,0 +1,227 @@
+package setter
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/davenicholson-xyz/go-setwallpaper/wallpaper"
+)
+
+// DefaultSetter uses go-setwallpaper which covers the common desktops
+type DefaultSetter struct{}
+
+func (s *DefaultSetter) Name() string {
+	return "default"
+}
+
+func (s *DefaultSetter) Set(path string) error {
+	if err := wallpaper.Set(path); err != nil {
+		return fmt.Errorf("%w: %w", ErrSetFailed, err)
+	}
+	return nil
+}
+
+type FehSetter struct{}
+
+func (s *FehSetter) Name() string {
+	return "feh"
+}
+
+func (s *FehSetter) Set(path string) error {
+	return run("feh", "--no-fehbg", "--bg-fill", path)
+}
+
+// SetOutputs relies on feh assigning images to screens in the order given.
+// feh uses the Xinerama screen order, which is not always the left to right
+// order of Outputs, so the assignments are sorted to match it first.
+func (s *FehSetter) SetOutputs(assignments []Assignment) error {
+	order, err := xineramaOrder()
+	if err != nil {
+		return err
+	}
+
+	assignments = slices.Clone(assignments)
+	slices.SortStableFunc(assignments, func(a, b Assignment) int {
+		return screenIndex(order, a.Output) - screenIndex(order, b.Output)
+	})
+
+	args := []string{"--no-fehbg", "--bg-fill"}
+	for _, a := range assignments {
+		args = append(args, a.Path)
+	}
+	return run("feh", args...)
+}
+
+// screenIndex is the position of an output in the Xinerama order, outputs
+// that are not connected go last
+func screenIndex(order []string, output string) int {
+	if i := slices.Index(order, output); i >= 0 {
+		return i
+	}
+	return len(order)
+}
+
+type XwallpaperSetter struct{}
+
+func (s *XwallpaperSetter) Name() string {
+	return "xwallpaper"
+}
+
+func (s *XwallpaperSetter) Set(path string) error {
+	return run("xwallpaper", "--zoom", path)
+}
+
+func (s *XwallpaperSetter) SetOutputs(assignments []Assignment) error {
+	var args []string
+	for _, a := range assignments {
+		args = append(args, "--output", a.Output, "--zoom", a.Path)
+	}
+	return run("xwallpaper", args...)
+}
+
+type GnomeSetter struct{}
+
+func (s *GnomeSetter) Name() string {
+	return "gnome"
+}
+
+func (s *GnomeSetter) Set(path string) error {
+	uri := "file://" + path
+	for _, key := range []string{"picture-uri", "picture-uri-dark"} {
+		if err := run("gsettings", "set", "org.gnome.desktop.background", key, uri); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SwaybgSetter replaces any running swaybg with a new one showing the image.
+// swaybg has to keep running for the wallpaper to stay visible.
+type SwaybgSetter struct{}
+
+func (s *SwaybgSetter) Name() string {
+	return "swaybg"
+}
+
+func (s *SwaybgSetter) Set(path string) error {
+	return startSwaybg("-m", "fill", "-i", path)
+}
+
+func (s *SwaybgSetter) SetOutputs(assignments []Assignment) error {
+	var args []string
+	for _, a := range assignments {
+		args = append(args, "-o", a.Output, "-m", "fill", "-i", a.Path)
+	}
+	return startSwaybg(args...)
+}
+
+func startSwaybg(args ...string) error {
+	// pkill exits 1 when there was nothing to kill
+	exec.Command("pkill", "-x", "swaybg").Run()
+
+	cmd := exec.Command("swaybg", args...)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("%w: swaybg: %v", ErrSetFailed, err)
+	}
+	return cmd.Process.Release()
+}
+
+// HyprpaperSetter talks to hyprpaper over its IPC socket
+type HyprpaperSetter struct{}
+
+func (s *HyprpaperSetter) Name() string {
+	return "hyprpaper"
+}
+
+func (s *HyprpaperSetter) Set(path string) error {
+	return hyprpaper(
+		"preload "+path,
+		"wallpaper ,"+path,
+		"unload unused",
+	)
+}
+
+func (s *HyprpaperSetter) SetOutputs(assignments []Assignment) error {
+	var requests []string
+	for _, a := range assignments {
+		requests = append(requests, "preload "+a.Path)
+	}
+	for _, a := range assignments {
+		requests = append(requests, fmt.Sprintf("wallpaper %s,%s", a.Output, a.Path))
+	}
+	requests = append(requests, "unload unused")
+
+	return hyprpaper(requests...)
+}
+
+func hyprpaperSocket() string {
+	runtime := os.Getenv("XDG_RUNTIME_DIR")
+	signature := os.Getenv("HYPRLAND_INSTANCE_SIGNATURE")
+	return filepath.Join(runtime, "hypr", signature, ".hyprpaper.sock")
+}
+
+// hyprpaper sends each request on its own connection, as hyprpaper closes the
+// socket after replying
+func hyprpaper(requests ...string) error {
+	for _, request := range requests {
+		conn, err := net.DialTimeout("unix", hyprpaperSocket(), 2*time.Second)
+		if err != nil {
+			return fmt.Errorf("%w: hyprpaper: %v", ErrSetFailed, err)
+		}
+
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		if _, err := conn.Write([]byte(request)); err != nil {
+			conn.Close()
+			return fmt.Errorf("%w: hyprpaper: %v", ErrSetFailed, err)
+		}
+
+		reply := make([]byte, 1024)
+		n, err := conn.Read(reply)
+		conn.Close()
+		if err != nil {
+			return fmt.Errorf("%w: hyprpaper: %v", ErrSetFailed, err)
+		}
+
+		if response := strings.TrimSpace(string(reply[:n])); response != "ok" {
+			return fmt.Errorf("%w: hyprpaper: %s: %s", ErrSetFailed, request, response)
+		}
+	}
+	return nil
+}
+
+// CommandSetter runs a user supplied command template through the shell,
+// with {IMG} replaced by the image path. For multiple outputs the command is
+// run once per output with {OUTPUT} replaced by the output name.
+type CommandSetter struct {
+	Template string
+}
+
+func (s *CommandSetter) Name() string {
+	return "command"
+}
+
+func (s *CommandSetter) Set(path string) error {
+	return s.SetOutputs([]Assignment{{Path: path}})
+}
+
+func (s *CommandSetter) SetOutputs(assignments []Assignment) error {
+	for _, a := range assignments {
+		command := strings.ReplaceAll(s.Template, "{IMG}", shellQuote(a.Path))
+		command = strings.ReplaceAll(command, "{OUTPUT}", shellQuote(a.Output))
+		if err := run("sh", "-c", command); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
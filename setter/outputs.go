@@ -0,0 +1,191 @@
+package setter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrNoOutputs = errors.New("Could not detect any outputs")
+)
+
+// Output is a connected monitor
+type Output struct {
+	Name   string `json:"name"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+}
+
+// Assignment is a wallpaper to show on a single output
+type Assignment struct {
+	Output string
+	Path   string
+}
+
+// MultiSetter is implemented by setters that can show a different wallpaper
+// on each output
+type MultiSetter interface {
+	SetOutputs(assignments []Assignment) error
+}
+
+// Outputs lists the active outputs using whichever tool matches the running
+// session. Outputs are sorted left to right, top to bottom.
+func Outputs() ([]Output, error) {
+	var (
+		outputs []Output
+		err     error
+	)
+
+	switch {
+	case os.Getenv("HYPRLAND_INSTANCE_SIGNATURE") != "":
+		outputs, err = commandOutputs(parseHyprMonitors, "hyprctl", "monitors", "-j")
+	case os.Getenv("SWAYSOCK") != "":
+		outputs, err = commandOutputs(parseSwayOutputs, "swaymsg", "-t", "get_outputs", "-r")
+	case os.Getenv("DISPLAY") != "":
+		outputs, err = commandOutputs(parseXrandr, "xrandr", "--query")
+	default:
+		return nil, fmt.Errorf("%w: no supported display server found", ErrNoOutputs)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(outputs) == 0 {
+		return nil, ErrNoOutputs
+	}
+
+	sort.SliceStable(outputs, func(i, j int) bool {
+		if outputs[i].X != outputs[j].X {
+			return outputs[i].X < outputs[j].X
+		}
+		return outputs[i].Y < outputs[j].Y
+	})
+
+	return outputs, nil
+}
+
+func commandOutputs(parse func([]byte) ([]Output, error), name string, args ...string) ([]Output, error) {
+	data, err := exec.Command(name, args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrNoOutputs, name, err)
+	}
+	return parse(data)
+}
+
+// e.g. "DP-1 connected primary 2560x1440+1920+0 (normal left inverted ..."
+var xrandrOutput = regexp.MustCompile(`^(\S+) connected (?:primary )?(\d+)x(\d+)\+(\d+)\+(\d+)`)
+
+func parseXrandr(data []byte) ([]Output, error) {
+	var outputs []Output
+	for _, line := range strings.Split(string(data), "\n") {
+		match := xrandrOutput.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		outputs = append(outputs, Output{
+			Name:   match[1],
+			Width:  atoi(match[2]),
+			Height: atoi(match[3]),
+			X:      atoi(match[4]),
+			Y:      atoi(match[5]),
+		})
+	}
+	return outputs, nil
+}
+
+// xineramaOrder lists the output names in the order X numbers the screens
+func xineramaOrder() ([]string, error) {
+	data, err := exec.Command("xrandr", "--listmonitors").Output()
+	if err != nil {
+		return nil, fmt.Errorf("%w: xrandr: %v", ErrNoOutputs, err)
+	}
+	return parseListMonitors(data), nil
+}
+
+// e.g. " 0: +*DP-1 2560/597x1440/336+0+0  DP-1"
+var xrandrMonitor = regexp.MustCompile(`^\s*(\d+): .*\s(\S+)$`)
+
+func parseListMonitors(data []byte) []string {
+	var names []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if match := xrandrMonitor.FindStringSubmatch(strings.TrimRight(line, " ")); match != nil {
+			names = append(names, match[2])
+		}
+	}
+	return names
+}
+
+func parseSwayOutputs(data []byte) ([]Output, error) {
+	var raw []struct {
+		Name   string `json:"name"`
+		Active bool   `json:"active"`
+		Rect   struct {
+			X      int `json:"x"`
+			Y      int `json:"y"`
+			Width  int `json:"width"`
+			Height int `json:"height"`
+		} `json:"rect"`
+		CurrentMode struct {
+			Width  int `json:"width"`
+			Height int `json:"height"`
+		} `json:"current_mode"`
+	}
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("Could not parse sway outputs: %w", err)
+	}
+
+	var outputs []Output
+	for _, o := range raw {
+		if !o.Active {
+			continue
+		}
+		outputs = append(outputs, Output{
+			Name:   o.Name,
+			Width:  o.CurrentMode.Width,
+			Height: o.CurrentMode.Height,
+			X:      o.Rect.X,
+			Y:      o.Rect.Y,
+		})
+	}
+	return outputs, nil
+}
+
+func parseHyprMonitors(data []byte) ([]Output, error) {
+	var raw []struct {
+		Name     string `json:"name"`
+		Width    int    `json:"width"`
+		Height   int    `json:"height"`
+		X        int    `json:"x"`
+		Y        int    `json:"y"`
+		Disabled bool   `json:"disabled"`
+	}
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("Could not parse hyprland monitors: %w", err)
+	}
+
+	var outputs []Output
+	for _, m := range raw {
+		if m.Disabled {
+			continue
+		}
+		outputs = append(outputs, Output{Name: m.Name, Width: m.Width, Height: m.Height, X: m.X, Y: m.Y})
+	}
+	return outputs, nil
+}
+
+func atoi(value string) int {
+	i, _ := strconv.Atoi(value)
+	return i
+}
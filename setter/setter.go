@@ -0,0 +1,112 @@
+package setter
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUnknownSetter = errors.New("Unknown wallpaper setter")
+	ErrSetFailed     = errors.New("Could not set wallpaper")
+)
+
+// Setter applies an image file as the desktop wallpaper
+type Setter interface {
+	Name() string
+	Set(path string) error
+}
+
+var (
+	setters     = make(map[string]Setter)
+	settersLock sync.RWMutex
+)
+
+func RegisterSetter(s Setter) {
+	settersLock.Lock()
+	defer settersLock.Unlock()
+	setters[s.Name()] = s
+}
+
+func GetSetter(name string) (Setter, bool) {
+	settersLock.RLock()
+	defer settersLock.RUnlock()
+	s, exists := setters[name]
+	return s, exists
+}
+
+func init() {
+	RegisterSetter(&DefaultSetter{})
+	RegisterSetter(&FehSetter{})
+	RegisterSetter(&SwaybgSetter{})
+	RegisterSetter(&HyprpaperSetter{})
+	RegisterSetter(&GnomeSetter{})
+	RegisterSetter(&XwallpaperSetter{})
+}
+
+// New returns the setter with the given name. An empty name or "auto" detects
+// the setter from the environment. The command setter is built from the
+// template, where {IMG} is replaced with the image path.
+func New(name string, command string) (Setter, error) {
+	if name == "" || name == "auto" {
+		name = Detect()
+	}
+
+	if name == "command" {
+		if command == "" {
+			return nil, fmt.Errorf("%w: command setter needs setter_command", ErrUnknownSetter)
+		}
+		return &CommandSetter{Template: command}, nil
+	}
+
+	s, exists := GetSetter(name)
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSetter, name)
+	}
+	return s, nil
+}
+
+// Detect picks a setter based on the desktop environment variables and which
+// tools are installed
+func Detect() string {
+	desktop := strings.ToLower(os.Getenv("XDG_CURRENT_DESKTOP"))
+
+	switch {
+	case os.Getenv("HYPRLAND_INSTANCE_SIGNATURE") != "" || strings.Contains(desktop, "hyprland"):
+		return "hyprpaper"
+	case os.Getenv("SWAYSOCK") != "" || strings.Contains(desktop, "sway"):
+		return "swaybg"
+	case strings.Contains(desktop, "gnome"):
+		return "gnome"
+	case os.Getenv("WAYLAND_DISPLAY") != "":
+		if installed("swaybg") {
+			return "swaybg"
+		}
+	case os.Getenv("DISPLAY") != "" && (desktop == "" || strings.Contains(desktop, "i3")):
+		if installed("feh") {
+			return "feh"
+		}
+		if installed("xwallpaper") {
+			return "xwallpaper"
+		}
+	}
+
+	return "default"
+}
+
+func installed(command string) bool {
+	_, err := exec.LookPath(command)
+	return err == nil
+}
+
+// run executes a command and includes its output in any error
+func run(name string, args ...string) error {
+	output, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v: %s", ErrSetFailed, name, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
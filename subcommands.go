@@ -0,0 +1,340 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/davenicholson-xyz/wallmancer/config"
+	"github.com/davenicholson-xyz/wallmancer/files"
+)
+
+var (
+	ErrUsage = errors.New("Invalid arguments")
+)
+
+// subcommand is a command given as the first argument, eg. wallmancer random
+// mountains. Most subcommands turn their arguments into the same flag values
+// the older flags use so both run the same way.
+type subcommand struct {
+	name    string
+	usage   string
+	summary string
+
+	// flags defines the flags that only this subcommand takes
+	flags func(flg *config.FlagSet)
+
+	// args sets flag values from the positional arguments
+	args func(values map[string]any, args []string) error
+
+	// run is used instead of args for subcommands that only need the config
+	run func(cfg *config.Config, args []string) (string, error)
+}
+
+var subcommands = []subcommand{
+	{
+		name:    "random",
+		usage:   "random <query> [flags]",
+		summary: "apply a random wallpaper matching the query",
+		flags: func(flg *config.FlagSet) {
+			defineSearchFlags(flg)
+			defineDaemonFlags(flg)
+		},
+		args: func(values map[string]any, args []string) error {
+			if len(args) == 0 {
+				return fmt.Errorf("%w: random needs a query", ErrUsage)
+			}
+			values["random"] = strings.Join(args, " ")
+			values["hot"] = false
+			values["top"] = false
+			return nil
+		},
+	},
+	{
+		name:    "hot",
+		usage:   "hot [flags]",
+		summary: "apply a wallpaper from the hot list",
+		flags: func(flg *config.FlagSet) {
+			defineSearchFlags(flg)
+			defineDaemonFlags(flg)
+		},
+		args: func(values map[string]any, args []string) error {
+			if len(args) > 0 {
+				return fmt.Errorf("%w: hot does not take a query", ErrUsage)
+			}
+			values["random"] = ""
+			values["hot"] = true
+			values["top"] = false
+			return nil
+		},
+	},
+	{
+		name:    "top",
+		usage:   "top [flags]",
+		summary: "apply a wallpaper from the toplist",
+		flags: func(flg *config.FlagSet) {
+			defineSearchFlags(flg)
+			defineDaemonFlags(flg)
+			flg.DefineString("topRange", "", "toplist time range (1d, 3d, 1w, 1M, 3M, 6M, 1y)")
+		},
+		args: func(values map[string]any, args []string) error {
+			if len(args) > 0 {
+				return fmt.Errorf("%w: top does not take a query", ErrUsage)
+			}
+			values["random"] = ""
+			values["hot"] = false
+			values["top"] = true
+			return nil
+		},
+	},
+	{
+		name:    "cache",
+		usage:   "cache clear|list|stats|prune|show <query>",
+		summary: "manage the wallpaper cache",
+		flags:   defineCacheFlags,
+		args: func(values map[string]any, args []string) error {
+			if len(args) > 1 && args[0] == "show" {
+				values["show"] = true
+				values["show_query"] = strings.Join(args[1:], " ")
+				return nil
+			}
+			actions := map[string]string{"clear": "clear", "list": "queries", "stats": "stats", "prune": "prune"}
+			if len(args) != 1 || actions[args[0]] == "" {
+				return fmt.Errorf("%w: cache needs one of clear, list, stats, prune or show <query>", ErrUsage)
+			}
+			values[actions[args[0]]] = true
+			return nil
+		},
+	},
+	{
+		name:    "config",
+		usage:   "config get <key>|set <key> <value>|path",
+		summary: "read and change the config file",
+		run:     runConfig,
+	},
+	{
+		name:    "history",
+		usage:   "history [flags]",
+		summary: "list wallpaper history",
+		args: func(values map[string]any, args []string) error {
+			if len(args) > 0 {
+				return fmt.Errorf("%w: history does not take arguments", ErrUsage)
+			}
+			values["history"] = true
+			return nil
+		},
+	},
+	{
+		name:    "ban",
+		usage:   "ban [tag <tag>|tags]",
+		summary: "blacklist the current wallpaper or a tag",
+		args: func(values map[string]any, args []string) error {
+			switch {
+			case len(args) == 0:
+				values["ban"] = true
+			case args[0] == "tag" && len(args) > 1:
+				values["ban_tag"] = true
+				values["tag"] = strings.Join(args[1:], " ")
+			case args[0] == "tags" && len(args) == 1:
+				values["banned_tags"] = true
+			default:
+				return fmt.Errorf("%w: ban takes no arguments, tag <tag> or tags", ErrUsage)
+			}
+			return nil
+		},
+	},
+	{
+		name:    "info",
+		usage:   "info [flags]",
+		summary: "show details of the current wallpaper",
+		args: func(values map[string]any, args []string) error {
+			if len(args) > 0 {
+				return fmt.Errorf("%w: info does not take arguments", ErrUsage)
+			}
+			values["info"] = true
+			return nil
+		},
+	},
+}
+
+// deprecatedFlags are the flags replaced by a subcommand
+var deprecatedFlags = map[string]string{
+	"random":  "wallmancer random <query>",
+	"hot":     "wallmancer hot",
+	"top":     "wallmancer top",
+	"clear":   "wallmancer cache clear",
+	"queries": "wallmancer cache list",
+	"prune":   "wallmancer cache prune",
+	"history": "wallmancer history",
+	"info":    "wallmancer info",
+	"ban":     "wallmancer ban",
+}
+
+func findSubcommand(name string) (subcommand, bool) {
+	for _, sub := range subcommands {
+		if sub.name == name {
+			return sub, true
+		}
+	}
+	return subcommand{}, false
+}
+
+// parseArgs parses the command line. When an argument names a subcommand
+// only the global flags and that subcommand's flags are accepted, otherwise
+// the older flags are used. Global flags may come before the subcommand.
+func parseArgs(args []string) (subcommand, map[string]any, []string) {
+	if i := subcommandIndex(args); i >= 0 {
+		globals, rest := args[:i:i], args[i+1:]
+		name := args[i]
+
+		if name == "help" {
+			if sub, ok := findSubcommand(valueAt(rest, 0)); ok {
+				name, rest = sub.name, []string{"-h"}
+			} else {
+				name, rest = "", []string{"-h"}
+			}
+		}
+
+		if sub, ok := findSubcommand(name); ok {
+			flg := config.NewNamedFlagSet("wallmancer " + sub.name)
+			defineGlobalFlags(flg)
+			if sub.flags != nil {
+				sub.flags(flg)
+			}
+			flg.SetUsage(func() {
+				fmt.Fprintf(flg.Output(), "Usage: wallmancer %s\n\n%s\n\nFlags:\n", sub.usage, sub.summary)
+				flg.PrintDefaults()
+			})
+
+			values, positional := flg.Parse(append(globals, rest...))
+			aliasJSON(values)
+			return sub, values, positional
+		}
+
+		args = append(globals, rest...)
+	}
+
+	flg := config.NewNamedFlagSet("wallmancer")
+	defineGlobalFlags(flg)
+	defineLegacyFlags(flg)
+	flg.SetUsage(func() {
+		out := flg.Output()
+		fmt.Fprintf(out, "Usage: wallmancer [command] [flags]\n\nCommands:\n")
+		for _, sub := range subcommands {
+			fmt.Fprintf(out, "  %-40s %s\n", sub.usage, sub.summary)
+		}
+		fmt.Fprintf(out, "\nRun wallmancer help <command> for the flags of a command.\n\nFlags:\n")
+		flg.PrintDefaults()
+	})
+
+	values, positional := flg.Parse(args)
+	warnDeprecated(values)
+	aliasJSON(values)
+
+	return subcommand{}, values, positional
+}
+
+// subcommandIndex returns the position of the subcommand (or help) in args,
+// skipping any global flags before it. It is -1 when there is none.
+func subcommandIndex(args []string) int {
+	globals := config.NewNamedFlagSet("wallmancer")
+	defineGlobalFlags(globals)
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return -1
+		}
+		if !strings.HasPrefix(arg, "-") {
+			if _, ok := findSubcommand(arg); ok || arg == "help" {
+				return i
+			}
+			return -1
+		}
+
+		name, _, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !globals.Has(name) {
+			return -1
+		}
+		if !hasValue && globals.TakesValue(name) {
+			i++
+		}
+	}
+
+	return -1
+}
+
+func valueAt(args []string, i int) string {
+	if i < len(args) {
+		return args[i]
+	}
+	return ""
+}
+
+// aliasJSON turns the older -json flag into -output json
+func aliasJSON(values map[string]any) {
+	if _, set := values["json"]; !set {
+		return
+	}
+	slog.Warn("-json is deprecated, use: -output json")
+	if values["json"] == true {
+		values["output"] = "json"
+	}
+	delete(values, "json")
+}
+
+// warnDeprecated warns about flags replaced by subcommands. The search flags
+// are still the way to set the query in daemon mode so they are not warned
+// about there.
+func warnDeprecated(values map[string]any) {
+	daemon := values["daemon"] == true
+
+	for _, name := range slices.Sorted(maps.Keys(deprecatedFlags)) {
+		replacement := deprecatedFlags[name]
+		if _, set := values[name]; !set {
+			continue
+		}
+		if daemon && (name == "random" || name == "hot" || name == "top") {
+			continue
+		}
+		slog.Warn(fmt.Sprintf("-%s is deprecated, use: %s", name, replacement))
+	}
+
+	var searches []string
+	for _, name := range []string{"random", "hot", "top"} {
+		if _, set := values[name]; set {
+			searches = append(searches, "-"+name)
+		}
+	}
+	if len(searches) > 1 {
+		slog.Warn(fmt.Sprintf("%s given together, top is used over hot and hot over random", strings.Join(searches, ", ")))
+	}
+}
+
+// runConfig handles the config subcommand
+func runConfig(cfg *config.Config, args []string) (string, error) {
+	path, _ := files.DefaultConfigFilepath()
+
+	switch {
+	case len(args) == 1 && args[0] == "path":
+		return path, nil
+
+	case len(args) == 2 && args[0] == "get":
+		value, ok := cfg.Get(args[1])
+		if !ok {
+			return "", fmt.Errorf("%w: %s", config.ErrKeyNotSet, args[1])
+		}
+		return fmt.Sprintf("%v", value), nil
+
+	case len(args) == 3 && args[0] == "set":
+		if err := config.SetInFile(path, args[1], args[2]); err != nil {
+			return "", fmt.Errorf("%w", err)
+		}
+		return fmt.Sprintf("%s set to %s", args[1], args[2]), nil
+	}
+
+	return "", fmt.Errorf("%w: config needs get <key>, set <key> <value> or path", ErrUsage)
+}